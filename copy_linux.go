@@ -0,0 +1,59 @@
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// Copies the contents of in to out without loading the whole file into memory. On filesystems
+// that support it (btrfs, xfs) we first try a reflink which shares the data blocks, then
+// copy_file_range which copies inside the kernel, and finally fall back to a regular buffered copy.
+func copyContents(out, in *os.File, size int64) error {
+	if err := cloneContents(out, in); err == nil {
+		return nil
+	}
+
+	var copied int64
+	for copied < size {
+		n, err := unix.CopyFileRange(int(in.Fd()), nil, int(out.Fd()), nil, int(min(size-copied, 1<<30)), 0)
+		if err != nil {
+			// Not supported for this pair of files (eg: across filesystems on older kernels)
+			if errors.Is(err, unix.EXDEV) || errors.Is(err, unix.ENOSYS) || errors.Is(err, unix.EINVAL) ||
+				errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EPERM) {
+				break
+			}
+			return err
+		}
+		if n == 0 {
+			break
+		}
+		copied += int64(n)
+	}
+
+	// Anything left over (or the whole file if copy_file_range is unavailable). The file offsets
+	// have been advanced by copy_file_range so this picks up where it stopped.
+	_, err := io.Copy(out, in)
+	return err
+}
+
+// Makes out share the data blocks of in (a reflink)
+func cloneContents(out, in *os.File) error {
+	return unix.IoctlFileClone(int(out.Fd()), int(in.Fd()))
+}
+
+// Makes the entries of dir durable, so a file renamed into it survives a crash. Some filesystems
+// can not sync a directory and say so with EINVAL, there is nothing more to do for them.
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.Sync(); err != nil && !errors.Is(err, unix.EINVAL) {
+		return err
+	}
+	return nil
+}
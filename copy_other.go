@@ -0,0 +1,31 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"io"
+	"os"
+)
+
+// Streams the contents of in to out with a fixed size buffer
+func copyContents(out, in *os.File, size int64) error {
+	_, err := io.Copy(out, in)
+	return err
+}
+
+// Reflinks are only supported on linux for now
+func cloneContents(out, in *os.File) error {
+	return errors.ErrUnsupported
+}
+
+// Directories can not be synced everywhere (windows does not allow it), so this is only a best
+// effort here
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	d.Sync()
+	return d.Close()
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strings"
+	"text/tabwriter"
+)
+
+// How much of a source tree a folder of the config matches
+type folderCoverage struct {
+	Folder       string
+	Depth        int
+	Matched      int // files the folder matches (after the parent filter)
+	MatchedBytes int64
+	Placed       int // files that end up in the folder itself
+	PlacedBytes  int64
+	FirstWins    int // files it would get under the first policy, the rest go to earlier siblings
+	Matchers     []matcherCoverage
+}
+
+// How many of the files a folder matched a single extension or pattern accepted
+type matcherCoverage struct {
+	Kind        string
+	Value       string
+	Contributed int
+}
+
+type coverageReport struct {
+	Files          int
+	Bytes          int64
+	Folders        []*folderCoverage // in the order of the config, parents before their sub-folders
+	Unmatched      int
+	UnmatchedBytes int64
+}
+
+// Runs the config against the files in the current directory (leaving out the folders the config
+// writes into) and counts what every folder and matcher does with them.
+func configCoverage(data ConfigData) (coverageReport, error) {
+	report := coverageReport{}
+
+	byPath := map[string]*folderCoverage{}
+	var addFolders func(parentDir string, folders []Folder, depth int)
+	addFolders = func(parentDir string, folders []Folder, depth int) {
+		for _, folder := range folders {
+			coverage := &folderCoverage{Folder: path.Join(parentDir, folder.Name), Depth: depth}
+			for _, extension := range folder.Extensions {
+				coverage.Matchers = append(coverage.Matchers, matcherCoverage{Kind: "extension", Value: extension})
+			}
+			for _, pattern := range folder.Patterns {
+				coverage.Matchers = append(coverage.Matchers, matcherCoverage{Kind: "pattern", Value: pattern})
+			}
+			report.Folders = append(report.Folders, coverage)
+			byPath[coverage.Folder] = coverage
+			addFolders(coverage.Folder, folder.ChildFolders, depth+1)
+		}
+	}
+	addFolders("", data.Folders, 0)
+
+	all, err := listCandidates()
+	if err != nil {
+		return report, err
+	}
+	managed := managedDirs(data)
+	candidates := []string{}
+	sizes := map[string]int64{}
+	for _, candidate := range all {
+		if insideDirs(candidate, managed) {
+			continue
+		}
+		info, err := os.Stat(candidate)
+		if err != nil {
+			continue
+		}
+		candidates = append(candidates, candidate)
+		sizes[candidate] = info.Size()
+		report.Files++
+		report.Bytes += info.Size()
+	}
+
+	for _, file := range candidates {
+		traces := []folderTrace{}
+		planConfigRecurse("", data.Folders, []string{}, true, []string{file}, data.Policy, &traces)
+		countTraces(traces, byPath, sizes[file])
+	}
+
+	plan, err := planConfig(data, candidates)
+	if err != nil {
+		return report, err
+	}
+	for _, op := range plan.Operations {
+		if coverage, ok := byPath[op.Folder]; ok {
+			coverage.Placed++
+			coverage.PlacedBytes += sizes[op.Src]
+		}
+	}
+	for _, file := range plan.Unmatched {
+		report.Unmatched++
+		report.UnmatchedBytes += sizes[file]
+	}
+	return report, nil
+}
+
+// Adds what the folders of one level did with a file to their coverage
+func countTraces(traces []folderTrace, byPath map[string]*folderCoverage, size int64) {
+	firstTaken := false
+	for _, trace := range traces {
+		if !trace.Matched || !trace.InParent {
+			continue
+		}
+		coverage := byPath[trace.Folder]
+		coverage.Matched++
+		coverage.MatchedBytes += size
+		if !firstTaken {
+			coverage.FirstWins++
+			firstTaken = true
+		}
+		for i, m := range trace.Matchers {
+			if m.Matched {
+				coverage.Matchers[i].Contributed++
+			}
+		}
+		countTraces(trace.Children, byPath, size)
+	}
+}
+
+func (r coverageReport) print(w io.Writer) {
+	fmt.Fprintf(w, "%s (%s)\n\n", plural(r.Files, "file"), formatBytes(r.Bytes))
+
+	table := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(table, "FOLDER\tMATCHED\tPLACED")
+	for _, f := range r.Folders {
+		fmt.Fprintf(table, "%s%s\t%d (%s)\t%d (%s)\n", strings.Repeat("  ", f.Depth), f.Folder,
+			f.Matched, formatBytes(f.MatchedBytes), f.Placed, formatBytes(f.PlacedBytes))
+	}
+	fmt.Fprintf(table, "(unmatched)\t%d (%s)\t\n", r.Unmatched, formatBytes(r.UnmatchedBytes))
+	table.Flush()
+
+	dead, shadowed, unused := []string{}, []string{}, []string{}
+	for _, f := range r.Folders {
+		if f.Matched == 0 {
+			dead = append(dead, f.Folder)
+			continue
+		}
+		if f.FirstWins == 0 {
+			shadowed = append(shadowed, f.Folder)
+		}
+		for _, m := range f.Matchers {
+			if m.Contributed == 0 {
+				unused = append(unused, fmt.Sprintf("%s: %s %q", f.Folder, m.Kind, m.Value))
+			}
+		}
+	}
+
+	printSection := func(title string, lines []string) {
+		if len(lines) == 0 {
+			return
+		}
+		fmt.Fprintf(w, "\n%s\n", title)
+		for _, line := range lines {
+			fmt.Fprintf(w, "  %s\n", line)
+		}
+	}
+	printSection("Folders that match nothing:", dead)
+	printSection("Folders shadowed by their siblings with policy first (earlier folders match all their files):", shadowed)
+	printSection("Extensions and patterns that never matched a file of their folder:", unused)
+}
+
+// RunCoverage prints how much of a directory every folder of the config covers
+func RunCoverage(configPath, dir string) error {
+	data, err := loadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Candidates are always relative to the current directory
+	if dir != "" {
+		if err := os.Chdir(dir); err != nil {
+			return err
+		}
+	}
+
+	report, err := configCoverage(data)
+	if err != nil {
+		return err
+	}
+	report.print(os.Stdout)
+	return nil
+}
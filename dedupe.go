@@ -0,0 +1,253 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"slices"
+)
+
+// What to do with a file whose contents were already put somewhere by the same plan
+const (
+	dedupeSkip     = "skip"     // do not write it at all
+	dedupeHardlink = "hardlink" // hard link it to the first copy
+	dedupeSymlink  = "symlink"  // symlink it to the first copy
+	dedupeReport   = "report"   // copy it anyway, but list it in the report
+)
+
+// Only this much of a file is hashed before deciding if it is worth hashing the whole thing
+const partialHashSize = 64 * 1024
+
+// Files which have the exact same contents
+type duplicateGroup struct {
+	Size  int64
+	Paths []string
+}
+
+// Hashes the first limit bytes of a file (or the whole file when limit is negative)
+func hashFile(fileName string, limit int64) (string, error) {
+	f, err := os.Open(fileName)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	var r io.Reader = f
+	if limit >= 0 {
+		r = io.LimitReader(f, limit)
+	}
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Splits each group by the hash of its files. Groups that end up with a single file are dropped
+// since they can not have a duplicate.
+func splitByHash(groups [][]string, limit int64) ([][]string, error) {
+	split := [][]string{}
+	for _, group := range groups {
+		byHash := map[string][]string{}
+		hashes := []string{}
+		for _, file := range group {
+			hash, err := hashFile(file, limit)
+			if err != nil {
+				return nil, err
+			}
+			if _, ok := byHash[hash]; !ok {
+				hashes = append(hashes, hash)
+			}
+			byHash[hash] = append(byHash[hash], file)
+		}
+		for _, hash := range hashes {
+			if len(byHash[hash]) > 1 {
+				split = append(split, byHash[hash])
+			}
+		}
+	}
+	return split, nil
+}
+
+// Finds the files with identical contents. Files are first bucketed by size, then by a hash of
+// their beginning and only the ones still together get hashed fully. Empty files are ignored,
+// there is nothing to be saved by deduplicating them.
+func findDuplicates(fileList []string) ([]duplicateGroup, error) {
+	sizes := map[int64][]string{}
+	order := []int64{}
+	seen := map[string]bool{}
+	for _, file := range fileList {
+		if seen[file] {
+			continue
+		}
+		seen[file] = true
+
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		if info.Size() == 0 || !info.Mode().IsRegular() {
+			continue
+		}
+		if _, ok := sizes[info.Size()]; !ok {
+			order = append(order, info.Size())
+		}
+		sizes[info.Size()] = append(sizes[info.Size()], file)
+	}
+
+	groups := []duplicateGroup{}
+	for _, size := range order {
+		if len(sizes[size]) < 2 {
+			continue
+		}
+
+		candidates, err := splitByHash([][]string{sizes[size]}, partialHashSize)
+		if err != nil {
+			return nil, err
+		}
+		// Small files were already hashed completely
+		if size > partialHashSize {
+			candidates, err = splitByHash(candidates, -1)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		for _, paths := range candidates {
+			groups = append(groups, duplicateGroup{Size: size, Paths: paths})
+		}
+	}
+	return groups, nil
+}
+
+// Rewrites a plan so that a file with the same contents as one already placed by the plan is
+// skipped or linked to that first copy instead of being copied again. This also covers the same
+// source being copied into several folders. Returns the new plan and the operations that were
+// deduplicated (in their original form).
+func dedupeOperations(ops []Operation, mode string) ([]Operation, []Operation, error) {
+	if mode == "" {
+		return ops, nil, nil
+	}
+
+	sources := []string{}
+	for _, op := range ops {
+		if op.Action == "" || op.Action == actionCopy {
+			sources = append(sources, op.Src)
+		}
+	}
+	groups, err := findDuplicates(sources)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// Files with the same contents share a key, everything else is keyed by its own path
+	contentKey := map[string]string{}
+	for _, group := range groups {
+		for _, file := range group.Paths {
+			contentKey[file] = group.Paths[0]
+		}
+	}
+
+	deduped := []Operation{}
+	duplicates := []Operation{}
+	firstCopy := map[string]Operation{}
+	for _, op := range ops {
+		if op.Action != "" && op.Action != actionCopy {
+			deduped = append(deduped, op)
+			continue
+		}
+
+		key, ok := contentKey[op.Src]
+		if !ok {
+			key = op.Src
+		}
+
+		first, seen := firstCopy[key]
+		if !seen || first.Dst == op.Dst {
+			firstCopy[key] = op
+			deduped = append(deduped, op)
+			continue
+		}
+
+		duplicates = append(duplicates, op)
+		switch mode {
+		case dedupeSkip:
+		case dedupeHardlink:
+			deduped = append(deduped, Operation{Src: first.Dst, Dst: op.Dst, Folder: op.Folder, Action: actionHardlink})
+		case dedupeSymlink:
+			deduped = append(deduped, Operation{Src: first.Dst, Dst: op.Dst, Folder: op.Folder, Action: actionSymlink})
+		default:
+			deduped = append(deduped, op)
+		}
+	}
+	return deduped, duplicates, nil
+}
+
+// Total size of the duplicates, worked out before the plan runs since running it can move the
+// files away
+func duplicatesSize(duplicates []Operation) int64 {
+	var size int64
+	for _, op := range duplicates {
+		if info, err := os.Stat(op.Src); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
+
+// Prints what dedupeOperations did, once the plan ran
+func printDedupeReport(w io.Writer, duplicates []Operation, mode string, size int64) {
+	if len(duplicates) == 0 {
+		return
+	}
+
+	if mode == dedupeReport {
+		fmt.Fprintf(w, "%s copied (deduplicating would save %s):\n", plural(len(duplicates), "duplicate file"), formatBytes(size))
+		for _, op := range duplicates {
+			fmt.Fprintf(w, "  %s -> %s\n", op.Src, op.Dst)
+		}
+		return
+	}
+	fmt.Fprintf(w, "Deduplicated %s (%s saved, action: %s)\n", plural(len(duplicates), "file"), formatBytes(size), mode)
+}
+
+// Lists the files in a directory (and all its sub directories) which have identical contents
+func FindDuplicatesInDir(dir string) ([]duplicateGroup, error) {
+	files := []string{}
+	err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.Type().IsRegular() {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	groups, err := findDuplicates(files)
+	if err != nil {
+		return nil, err
+	}
+
+	// Biggest wasted space first
+	slices.SortStableFunc(groups, func(a, b duplicateGroup) int {
+		wastedA := a.Size * int64(len(a.Paths)-1)
+		wastedB := b.Size * int64(len(b.Paths)-1)
+		switch {
+		case wastedA > wastedB:
+			return -1
+		case wastedA < wastedB:
+			return 1
+		}
+		return 0
+	})
+	return groups, nil
+}
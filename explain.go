@@ -0,0 +1,156 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// How a single extension or pattern of a folder did against the file
+type matcherTrace struct {
+	Kind    string `json:"kind"` // extension or pattern
+	Value   string `json:"value"`
+	Matched bool   `json:"matched"`
+}
+
+// How a folder of the config handled the file, as traced by planConfigRecurse
+type folderTrace struct {
+	Folder   string         `json:"folder"`
+	Priority int            `json:"priority,omitempty"`
+	InScope  bool           `json:"in_scope"` // false when the file is in a sub directory and the folder does not recurse
+	Matchers []matcherTrace `json:"matchers"`
+	Matched  bool           `json:"matched"`   // whether the matchers of the folder accept the file
+	InParent bool           `json:"in_parent"` // whether the parent folder matched it too (always true at the top level)
+
+	ClaimedBy   []string      `json:"claimed_by,omitempty"`  // sub-folders that took the file from this one
+	DroppedBy   string        `json:"dropped_by,omitempty"`  // policy that gave the file to a sibling instead
+	Destination string        `json:"destination,omitempty"` // where this folder itself puts the file
+	Children    []folderTrace `json:"children,omitempty"`
+}
+
+// Everything that decides where a file goes
+type explanation struct {
+	File       string        `json:"file"`
+	Folders    []folderTrace `json:"folders"`
+	Operations []Operation   `json:"operations"`
+	Unmatched  bool          `json:"unmatched"`
+}
+
+// Works out why a file ends up where it does. The file is relative to the current directory, it
+// does not have to exist since only its path is matched.
+func explainFile(data ConfigData, file string) (explanation, error) {
+	result := explanation{File: file, Folders: []folderTrace{}}
+
+	// The trace comes from planning the file itself, so it always says why the plan does what it does
+	plan, err := planConfigTraced(data, []string{file}, &result.Folders)
+	if err != nil {
+		return result, err
+	}
+	result.Operations = plan.Operations
+	result.Unmatched = slices.Contains(plan.Unmatched, file)
+	return result, nil
+}
+
+// Prints the explanation for people
+func (e explanation) print(w io.Writer) {
+	fmt.Fprintf(w, "%s\n\n", e.File)
+	printFolderTraces(w, e.Folders, 0)
+
+	fmt.Fprintln(w)
+	if len(e.Operations) == 0 {
+		fmt.Fprintln(w, "Result: not organized, it does not match any folder")
+		return
+	}
+	if e.Unmatched {
+		fmt.Fprintln(w, "Result: it does not match any folder, so it goes to the unmatched folder")
+	} else {
+		fmt.Fprintln(w, "Result:")
+	}
+	for _, op := range e.Operations {
+		fmt.Fprintf(w, "  %s -> %s\n", op.Action, op.Dst)
+	}
+}
+
+func printFolderTraces(w io.Writer, traces []folderTrace, depth int) {
+	indent := strings.Repeat("  ", depth)
+	for _, trace := range traces {
+		fmt.Fprintf(w, "%s%s/", indent, path.Base(trace.Folder))
+		if trace.Priority != 0 {
+			fmt.Fprintf(w, " (priority %d)", trace.Priority)
+		}
+		fmt.Fprintln(w)
+
+		if !trace.InScope {
+			fmt.Fprintf(w, "%s  skipped: the file is in a sub directory and the folder does not recurse\n", indent)
+		} else {
+			for _, m := range trace.Matchers {
+				verdict := "no match"
+				if m.Matched {
+					verdict = "matched"
+				}
+				fmt.Fprintf(w, "%s  %s %q: %s\n", indent, m.Kind, m.Value, verdict)
+			}
+			if len(trace.Matchers) == 0 {
+				fmt.Fprintf(w, "%s  no extensions or patterns, matches nothing\n", indent)
+			}
+		}
+
+		switch {
+		case !trace.Matched:
+			fmt.Fprintf(w, "%s  => not matched\n", indent)
+		case !trace.InParent:
+			fmt.Fprintf(w, "%s  => matched, but filtered out since the parent folder did not match\n", indent)
+		case len(trace.ClaimedBy) > 0:
+			fmt.Fprintf(w, "%s  => matched, claimed by %s\n", indent, strings.Join(trace.ClaimedBy, ", "))
+		default:
+			fmt.Fprintf(w, "%s  => matched, goes to %s\n", indent, trace.Destination)
+		}
+		if trace.DroppedBy != "" {
+			fmt.Fprintf(w, "%s  => dropped by policy %s, a sibling folder gets the file instead\n", indent, trace.DroppedBy)
+		}
+
+		printFolderTraces(w, trace.Children, depth+1)
+	}
+}
+
+// RunExplain prints why a file goes where it does with a config, as text or as JSON
+func RunExplain(configPath, file string, asJSON bool) error {
+	data, err := loadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	// Candidates are always relative to the current directory
+	rel, err := filepath.Rel(".", file)
+	if filepath.IsAbs(file) {
+		wd, wdErr := os.Getwd()
+		if wdErr != nil {
+			return wdErr
+		}
+		rel, err = filepath.Rel(wd, file)
+	}
+	if err != nil {
+		return err
+	}
+	rel = filepath.ToSlash(rel)
+	if rel == ".." || strings.HasPrefix(rel, "../") {
+		return fmt.Errorf("%s is not inside the current directory", file)
+	}
+
+	e, err := explainFile(data, rel)
+	if err != nil {
+		return err
+	}
+	if asJSON {
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(e)
+	}
+	e.print(os.Stdout)
+	return nil
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"bytes"
 	"errors"
+	"fmt"
+	"io"
 	"os"
 	"path"
+	"slices"
+	"strconv"
+	"strings"
 	"testing"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
 )
 
 var subDirName, tempDir string
@@ -16,6 +26,12 @@ func TestMain(m *testing.M) {
   tempDir, err = os.MkdirTemp("", "fileo-testing")
   HandleError(err)
 
+  // Keep the trash and state of the tests away from the real ones
+  xdgDir, err := os.MkdirTemp("", "fileo-testing-xdg")
+  HandleError(err)
+  os.Setenv("XDG_DATA_HOME", path.Join(xdgDir, "data"))
+  os.Setenv("XDG_STATE_HOME", path.Join(xdgDir, "state"))
+
   os.Chdir(tempDir)
   files := []string{
     "python1.py",
@@ -60,6 +76,7 @@ func TestMain(m *testing.M) {
 
   // cleanup
   os.RemoveAll(tempDir)
+  os.RemoveAll(xdgDir)
   os.Exit(code)
 }
 
@@ -168,7 +185,7 @@ func TestApplyConfig(t *testing.T) {
   err := os.WriteFile("test_config.yaml", []byte(sampleConfig), os.ModePerm)
   HandleError(err)
 
-  err = ApplyConfigFromFile("test_config.yaml")
+  err = ApplyConfigFromFile("test_config.yaml", applyOptions{jobs: defaultJobs()})
   HandleError(err)
 
 
@@ -229,6 +246,1300 @@ func TestCopyFile(t *testing.T) {
 }
 
 
+func TestCopyFileContents(t *testing.T) {
+  dir := t.TempDir()
+  src := path.Join(dir, "big.bin")
+  data := bytes.Repeat([]byte("fileo"), 1<<18)
+  err := os.WriteFile(src, data, 0644)
+  HandleError(err)
+
+  outDir := path.Join(dir, "out")
+  if err := copyFile(src, outDir); err != nil {
+    t.Fatalf("CopyFile failed: %v", err)
+  }
+
+  copied, err := os.ReadFile(path.Join(outDir, "big.bin"))
+  if err != nil || !bytes.Equal(copied, data) {
+    t.Error("CopyFile failed. Contents of the copy do not match the source")
+  }
+
+  // The temporary file should have been renamed into place
+  entries, _ := os.ReadDir(outDir)
+  if len(entries) != 1 {
+    t.Errorf("CopyFile left extra files behind in the destination: %d != 1", len(entries))
+  }
+}
+
+func TestExecutePlanOrdering(t *testing.T) {
+  dir := t.TempDir()
+  ops := []Operation{}
+  for i := range 20 {
+    src := path.Join(dir, fmt.Sprintf("src%d", i), "same.txt")
+    os.MkdirAll(path.Dir(src), os.ModePerm)
+    err := os.WriteFile(src, []byte(fmt.Sprint(i)), 0644)
+    HandleError(err)
+    ops = append(ops, Operation{Src: src, Dst: path.Join(dir, "out", "same.txt")})
+  }
+
+  if err := executePlan(ops, applyOptions{jobs: 8}); err != nil {
+    t.Fatalf("executePlan failed: %v", err)
+  }
+
+  // Operations on the same destination run in plan order, so the last one always wins
+  data, _ := os.ReadFile(path.Join(dir, "out", "same.txt"))
+  if string(data) != "19" {
+    t.Errorf("executePlan did not keep the order for a destination: got %q", data)
+  }
+}
+
+func TestDedupeOperations(t *testing.T) {
+  dir := t.TempDir()
+  a := path.Join(dir, "a.txt")
+  b := path.Join(dir, "b.txt")
+  c := path.Join(dir, "c.txt")
+  os.WriteFile(a, []byte("same contents"), 0644)
+  os.WriteFile(b, []byte("same contents"), 0644)
+  os.WriteFile(c, []byte("other contents"), 0644)
+
+  ops := []Operation{
+    {Src: a, Dst: path.Join(dir, "out", "a.txt")},
+    {Src: b, Dst: path.Join(dir, "out", "b.txt")},
+    {Src: c, Dst: path.Join(dir, "out", "c.txt")},
+    {Src: c, Dst: path.Join(dir, "out", "sibling", "c.txt")},
+  }
+
+  deduped, duplicates, err := dedupeOperations(ops, dedupeHardlink)
+  if err != nil {
+    t.Fatalf("dedupeOperations failed: %v", err)
+  }
+  if len(duplicates) != 2 {
+    t.Errorf("Expected 2 duplicates, got %d", len(duplicates))
+  }
+
+  if err := executePlan(deduped, applyOptions{}); err != nil {
+    t.Fatalf("executePlan failed: %v", err)
+  }
+
+  first, _ := os.Stat(path.Join(dir, "out", "a.txt"))
+  second, _ := os.Stat(path.Join(dir, "out", "b.txt"))
+  if first == nil || second == nil || !os.SameFile(first, second) {
+    t.Error("Duplicate was not hard linked to the first copy")
+  }
+
+  skipped, _, _ := dedupeOperations(ops, dedupeSkip)
+  if len(skipped) != 2 {
+    t.Errorf("Expected duplicates to be skipped: %d != 2", len(skipped))
+  }
+
+  // Nothing is saved when the duplicates are only reported
+  var report bytes.Buffer
+  printDedupeReport(&report, duplicates, dedupeReport, duplicatesSize(duplicates))
+  if !strings.Contains(report.String(), "would save 27 B") {
+    t.Errorf("Wrong report: %q", report.String())
+  }
+  report.Reset()
+  printDedupeReport(&report, duplicates, dedupeSkip, duplicatesSize(duplicates))
+  if !strings.HasPrefix(report.String(), "Deduplicated 2 files (27 B saved") {
+    t.Errorf("Wrong summary: %q", report.String())
+  }
+}
+
+func TestOperationActions(t *testing.T) {
+  dir := t.TempDir()
+  linked := path.Join(dir, "linked.txt")
+  moved := path.Join(dir, "moved.txt")
+  os.WriteFile(linked, []byte("link me"), 0644)
+  os.WriteFile(moved, []byte("move me"), 0644)
+
+  ops := resolveMoves([]Operation{
+    {Src: linked, Dst: path.Join(dir, "links", "linked.txt"), Action: actionSymlink},
+    {Src: linked, Dst: path.Join(dir, "hard", "linked.txt"), Action: actionHardlink},
+    {Src: moved, Dst: path.Join(dir, "copied", "moved.txt"), Action: actionMove},
+    {Src: moved, Dst: path.Join(dir, "moved", "moved.txt"), Action: actionMove},
+  })
+  if err := executePlan(ops, applyOptions{}); err != nil {
+    t.Fatalf("executePlan failed: %v", err)
+  }
+
+  if target, err := os.Readlink(path.Join(dir, "links", "linked.txt")); err != nil || target != "../linked.txt" {
+    t.Errorf("Symlink action failed: %q %v", target, err)
+  }
+
+  original, _ := os.Stat(linked)
+  hardlink, _ := os.Stat(path.Join(dir, "hard", "linked.txt"))
+  if hardlink == nil || !os.SameFile(original, hardlink) {
+    t.Error("Hardlink action failed")
+  }
+
+  // Only the last move actually moves the file, the other one copies it first
+  if _, err := os.Stat(moved); !errors.Is(err, os.ErrNotExist) {
+    t.Error("Move action did not remove the source")
+  }
+  for _, dst := range []string{"copied/moved.txt", "moved/moved.txt"} {
+    if data, _ := os.ReadFile(path.Join(dir, dst)); string(data) != "move me" {
+      t.Errorf("Move action failed for %s", dst)
+    }
+  }
+
+  if _, err := parseConfig([]byte("folders:\n- name: x\n  action: teleport\n")); err == nil || !strings.HasPrefix(err.Error(), "line 3:") {
+    t.Errorf("parseConfig should fail on an unknown action, on the line of the action: %v", err)
+  }
+  if _, err := parseConfig([]byte("folders:\n- name: x\n  patterns:\n  - ok\n  - '(broken'\n")); err == nil || !strings.HasPrefix(err.Error(), "line 5:") {
+    t.Errorf("An invalid pattern should be reported on its own line: %v", err)
+  }
+}
+
+func TestWatchWanted(t *testing.T) {
+  state := &watchState{
+    root:       "/data",
+    configPath: "/data/fileo.yaml",
+    data:       ConfigData{Folders: []Folder{{Name: "documents"}}},
+    recursive:  true,
+  }
+
+  wanted := map[string]bool{
+    "report.txt":                true,
+    "sub/report.txt":            true,
+    "movie.mkv.part":            false,
+    "setup.exe.crdownload":      false,
+    "documents/report.txt":      false,
+    ".report.txt.fileo-1234":    false,
+    "fileo.yaml":                false,
+  }
+  for file, expected := range wanted {
+    if state.wanted(file) != expected {
+      t.Errorf("Watch mode wanted(%q) should be %v", file, expected)
+    }
+  }
+
+  state.recursive = false
+  if state.wanted("sub/report.txt") {
+    t.Error("Watch mode should ignore sub directories when no rule recurses")
+  }
+}
+
+func TestStateSkipsOrganizedFiles(t *testing.T) {
+  dir := t.TempDir()
+  src := path.Join(dir, "notes.txt")
+  os.WriteFile(src, []byte("first version"), 0644)
+
+  state, err := loadState(path.Join(dir, "state", "state.jsonl"))
+  HandleError(err)
+
+  data := ConfigData{Preserve: defaultPreserve}
+  ops := []Operation{{Src: src, Dst: path.Join(dir, "out", "notes.txt")}}
+  if err := applyPlan(data, ops, applyOptions{state: state}); err != nil {
+    t.Fatalf("applyPlan failed: %v", err)
+  }
+
+  // Load it again from disk to make sure it was saved
+  state, err = loadState(path.Join(dir, "state", "state.jsonl"))
+  HandleError(err)
+  if len(state.filter(ops, "")) != 0 {
+    t.Error("State did not skip a file that was already organized")
+  }
+
+  // The hash is worked out while copying, unless the copy was a reflink which never reads it
+  hash, err := hashFile(src, -1)
+  HandleError(err)
+  for _, entry := range state.entries {
+    if entry.Hash == "" {
+      continue
+    }
+    if entry.Hash != hash {
+      t.Errorf("Wrong hash recorded: %s != %s", entry.Hash, hash)
+    }
+
+    // Only the time changed
+    later := time.Now().Add(time.Hour)
+    HandleError(os.Chtimes(src, later, later))
+    if len(state.filter(ops, "")) != 0 {
+      t.Error("State did not skip a file whose contents did not change")
+    }
+  }
+
+  os.WriteFile(src, []byte("second version"), 0644)
+  if len(state.filter(ops, "")) != 1 {
+    t.Error("State skipped a file that changed")
+  }
+
+  os.Remove(src)
+  if state.prune() != 1 {
+    t.Error("State prune did not drop the entry of a removed file")
+  }
+
+  // Duplicates skipped by dedupe are remembered too, as long as they are still skipped
+  a := path.Join(dir, "a.txt")
+  b := path.Join(dir, "b.txt")
+  os.WriteFile(a, []byte("same"), 0644)
+  os.WriteFile(b, []byte("same"), 0644)
+  ops = []Operation{{Src: a, Dst: path.Join(dir, "out", "a.txt")}, {Src: b, Dst: path.Join(dir, "out", "b.txt")}}
+  data.Dedupe = dedupeSkip
+  if err := applyPlan(data, ops, applyOptions{state: state, out: io.Discard}); err != nil {
+    t.Fatalf("applyPlan failed: %v", err)
+  }
+  if left := state.filter(ops, dedupeSkip); len(left) != 0 {
+    t.Errorf("The skipped duplicate is done again: %v", left)
+  }
+  if left := state.filter(ops, ""); len(left) != 1 || left[0].Src != b {
+    t.Errorf("The skipped duplicate should be copied once duplicates are not skipped: %v", left)
+  }
+}
+
+func TestSync(t *testing.T) {
+  dir := chdirTemp(t)
+  os.WriteFile("keep.txt", []byte("keep"), 0644)
+  os.WriteFile("gone.txt", []byte("gone"), 0644)
+
+  data, err := parseConfig([]byte("sync:\n  trash: old\nfolders:\n- name: docs\n  extensions: [txt]\n"))
+  HandleError(err)
+
+  diff, err := planSync(data)
+  HandleError(err)
+  if len(diff.add) != 2 || len(diff.remove) != 0 {
+    t.Fatalf("Sync should only add the two files: %+v", diff)
+  }
+  HandleError(applySync(data, diff, applyOptions{}))
+
+  os.Remove("gone.txt")
+  os.WriteFile("keep.txt", []byte("changed"), 0644)
+  diff, err = planSync(data)
+  HandleError(err)
+  if len(diff.add) != 0 || len(diff.update) != 1 || len(diff.remove) != 1 {
+    t.Fatalf("Sync diff is wrong after changing the sources: %+v", diff)
+  }
+  HandleError(applySync(data, diff, applyOptions{}))
+
+  if _, err := os.Stat(path.Join(dir, "old", "docs", "gone.txt")); err != nil {
+    t.Error("Sync did not move the removed file to the trash folder")
+  }
+  if data, _ := os.ReadFile("docs/keep.txt"); string(data) != "changed" {
+    t.Error("Sync did not update the changed file")
+  }
+
+  diff, err = planSync(data)
+  HandleError(err)
+  if !diff.empty() {
+    t.Errorf("Sync should have nothing left to do: %+v", diff)
+  }
+}
+
+func TestSyncLeavesSourcesAlone(t *testing.T) {
+  chdirTemp(t)
+  for _, config := range []string{"- name: .\n", "- extensions: [txt]\n", "- name: ../out\n", "- name: /tmp/out\n", "- name: docs\n  folders:\n  - name: ..\n"} {
+    if _, err := parseConfig([]byte("folders:\n" + config)); err == nil {
+      t.Errorf("parseConfig should refuse a folder outside of where it is in: %q", config)
+    }
+  }
+  if _, err := parseConfig([]byte("unmatched: .\nfolders:\n- name: docs\n")); err == nil {
+    t.Error("parseConfig should refuse the current directory as the unmatched folder")
+  }
+
+  // The config is never removed, even from a managed folder
+  HandleError(os.MkdirAll("docs", 0755))
+  HandleError(os.WriteFile("docs/fileo.yaml", []byte("folders:\n- name: docs\n  extensions: [txt]\n"), 0644))
+  HandleError(os.WriteFile("a.txt", []byte("same"), 0644))
+  HandleError(os.WriteFile("b.txt", []byte("same"), 0644))
+  data, err := loadConfigFile("docs/fileo.yaml")
+  HandleError(err)
+  diff, err := planSync(data)
+  HandleError(err)
+  if len(diff.add) != 2 || len(diff.remove) != 0 {
+    t.Errorf("Sync should only add the two files: %+v", diff)
+  }
+
+  // Skipped duplicates are not added again by every sync
+  data.Dedupe = dedupeSkip
+  diff, err = planSync(data)
+  HandleError(err)
+  HandleError(applySync(data, diff, applyOptions{out: io.Discard}))
+  if diff, err = planSync(data); err != nil || !diff.empty() {
+    t.Errorf("Sync should have nothing left to do with dedupe skip: %+v", diff)
+  }
+}
+
+func TestTrash(t *testing.T) {
+  dir := t.TempDir()
+  t.Setenv("XDG_DATA_HOME", path.Join(dir, "data"))
+
+  file := path.Join(dir, "precious.txt")
+  os.WriteFile(file, []byte("first"), 0644)
+  HandleError(moveToTrash(file))
+  os.WriteFile(file, []byte("second"), 0644)
+  HandleError(moveToTrash(file))
+
+  items, err := listTrash()
+  HandleError(err)
+  if len(items) != 2 || items[0].Path != file || items[0].Name == items[1].Name {
+    t.Fatalf("Trash does not list both trashed files: %+v", items)
+  }
+
+  item, err := findInTrash(file)
+  HandleError(err)
+  HandleError(restoreFromTrash(item))
+  if data, _ := os.ReadFile(file); string(data) != "second" {
+    t.Errorf("Trash did not restore the most recent file: %q", data)
+  }
+  if restoreFromTrash(items[1]) == nil {
+    t.Error("Trash restored over an existing file")
+  }
+
+  // Writing over a different file puts the old one in the trash first
+  os.WriteFile(path.Join(dir, "new.txt"), []byte("new"), 0644)
+  os.WriteFile(path.Join(dir, "old.txt"), []byte("older"), 0644)
+  HandleError(executePlan([]Operation{{Src: path.Join(dir, "new.txt"), Dst: path.Join(dir, "old.txt")}}, applyOptions{}))
+  if _, err := findInTrash(path.Join(dir, "old.txt")); err != nil {
+    t.Error("Overwritten file was not put in the trash")
+  }
+
+  // So does organizing with -e and -p
+  os.MkdirAll(path.Join(dir, "out"), 0755)
+  os.WriteFile(path.Join(dir, "out", "new.txt"), []byte("organized before"), 0644)
+  HandleError(copyMatchedFiles([]string{path.Join(dir, "new.txt")}, path.Join(dir, "out"), defaultPreserve))
+  if _, err := findInTrash(path.Join(dir, "out", "new.txt")); err != nil {
+    t.Error("File overwritten by copyMatchedFiles was not put in the trash")
+  }
+}
+
+func TestTrashInfoRelativePath(t *testing.T) {
+  top := t.TempDir()
+  uid := strconv.Itoa(os.Getuid())
+
+  // Relative paths start at the mount point, for the shared trash and the one of the user
+  for _, dir := range []string{path.Join(top, ".Trash", uid), path.Join(top, ".Trash-"+uid)} {
+    HandleError(os.MkdirAll(path.Join(dir, "info"), 0700))
+    infoFile := path.Join(dir, "info", "notes.txt.trashinfo")
+    HandleError(os.WriteFile(infoFile, []byte("[Trash Info]\nPath=docs/notes.txt\nDeletionDate=2024-01-02T03:04:05\n"), 0600))
+
+    item, err := readTrashInfo(dir, infoFile)
+    HandleError(err)
+    if item.Path != path.Join(top, "docs", "notes.txt") {
+      t.Errorf("Wrong path for %s: %s", dir, item.Path)
+    }
+  }
+}
+
+func TestUnmatchedFiles(t *testing.T) {
+  data, err := parseConfig([]byte("unmatched: other\nfolders:\n- name: docs\n  extensions: [txt]\n"))
+  HandleError(err)
+
+  data.configFile = "fileo.yaml"
+
+  candidates := []string{"a.txt", "b.jpg", "sub/c.jpg", "docs/old.txt", "fileo.yaml"}
+  plan, err := planConfig(data, candidates)
+  HandleError(err)
+
+  // Sub directories only count when a folder recurses, and the config's own folders never do.
+  // Neither does the config.
+  if !slices.Equal(plan.Unmatched, []string{"b.jpg"}) {
+    t.Errorf("Wrong unmatched files: %v", plan.Unmatched)
+  }
+  if len(plan.Operations) != 2 || plan.Operations[1].Dst != "other/b.jpg" {
+    t.Errorf("Unmatched files were not put in the unmatched folder: %+v", plan.Operations)
+  }
+
+  // Nor is anything in a trash
+  chdirTemp(t)
+  HandleError(os.WriteFile("fileo.yaml", []byte("unmatched: other\nsync:\n  trash: old\nfolders:\n- name: docs\n  recurse: true\n  extensions: [txt]\n"), 0644))
+  data, err = loadConfigFile("fileo.yaml")
+  HandleError(err)
+  candidates = []string{"b.jpg", "sub/c.jpg", "fileo.yaml", "old/d.jpg", ".Trash-1000/files/e.jpg", ".Trash/1000/files/f.jpg"}
+  plan, err = planConfig(data, candidates)
+  HandleError(err)
+  if !slices.Equal(plan.Unmatched, []string{"b.jpg", "sub/c.jpg"}) {
+    t.Errorf("Wrong unmatched files with recurse: %v", plan.Unmatched)
+  }
+}
+
+func TestPolicies(t *testing.T) {
+  config := `
+policy: %s
+folders:
+- name: text
+  extensions: [txt]
+- name: notes
+  patterns: [notes]
+  priority: %d
+  folders:
+  - name: work
+    patterns: [work]
+`
+  candidates := []string{"notes.txt", "work-notes.txt", "todo.txt"}
+  destinations := func(policy string, priority int) []string {
+    data, err := parseConfig([]byte(fmt.Sprintf(config, policy, priority)))
+    HandleError(err)
+    plan, err := planConfig(data, candidates)
+    HandleError(err)
+    dsts := []string{}
+    for _, op := range plan.Operations {
+      dsts = append(dsts, op.Dst)
+    }
+    slices.Sort(dsts)
+    return dsts
+  }
+
+  all := []string{"notes/notes.txt", "notes/work/work-notes.txt", "text/notes.txt", "text/todo.txt", "text/work-notes.txt"}
+  if dsts := destinations("all", 0); !slices.Equal(dsts, all) {
+    t.Errorf("Policy all should copy into every matching folder: %v", dsts)
+  }
+  if dsts := destinations("first", 5); !slices.Equal(dsts, []string{"text/notes.txt", "text/todo.txt", "text/work-notes.txt"}) {
+    t.Errorf("Policy first should only use the first matching folder: %v", dsts)
+  }
+  // Same priority, so the deepest folder wins
+  if dsts := destinations("best", 0); !slices.Equal(dsts, []string{"notes/work/work-notes.txt", "text/notes.txt", "text/todo.txt"}) {
+    t.Errorf("Policy best should prefer the deepest folder: %v", dsts)
+  }
+  if dsts := destinations("best", 1); !slices.Equal(dsts, []string{"notes/notes.txt", "notes/work/work-notes.txt", "text/todo.txt"}) {
+    t.Errorf("Policy best should prefer the folder with the highest priority: %v", dsts)
+  }
+
+  // A sub-folder can go back to priority 0, it only inherits the priority when it sets none
+  data, err := parseConfig([]byte(strings.Replace(fmt.Sprintf(config, "best", 5), "[txt]\n", "[txt]\n  priority: 1\n", 1) + "    priority: 0\n"))
+  HandleError(err)
+  plan, err := planConfig(data, []string{"work-notes.txt"})
+  HandleError(err)
+  if len(plan.Operations) != 1 || plan.Operations[0].Dst != "text/work-notes.txt" {
+    t.Errorf("An explicit priority 0 should not be inherited: %+v", plan.Operations)
+  }
+
+  if _, err := parseConfig([]byte("policy: random\nfolders:\n- name: a\n")); err == nil {
+    t.Error("parseConfig should fail on an unknown policy")
+  }
+}
+
+func TestExplain(t *testing.T) {
+  data, err := parseConfig([]byte(`
+policy: first
+folders:
+- name: text
+  extensions: [txt]
+- name: notes
+  patterns: [notes]
+  folders:
+  - name: work
+    patterns: [work]
+`))
+  HandleError(err)
+
+  e, err := explainFile(data, "work-notes.txt")
+  HandleError(err)
+  if len(e.Operations) != 1 || e.Operations[0].Dst != "text/work-notes.txt" {
+    t.Errorf("Wrong operations in the explanation: %+v", e.Operations)
+  }
+
+  notes := e.Folders[1]
+  if !notes.Matched || !slices.Equal(notes.ClaimedBy, []string{"notes/work"}) || notes.DroppedBy != "first" {
+    t.Errorf("Wrong trace for the notes folder: %+v", notes)
+  }
+  if work := notes.Children[0]; !work.Matched || work.Destination != "notes/work/work-notes.txt" {
+    t.Errorf("Wrong trace for the work folder: %+v", work)
+  }
+
+  e, err = explainFile(data, "work.jpg")
+  HandleError(err)
+  if len(e.Operations) != 0 || e.Folders[1].Matched || !e.Folders[1].Children[0].Matched || e.Folders[1].Children[0].InParent {
+    t.Errorf("A sub-folder matched a file its parent did not: %+v", e)
+  }
+}
+
+func TestConfigCoverage(t *testing.T) {
+  chdirTemp(t)
+  for _, file := range []string{"notes.txt", "work-notes.txt", "b.jpg", "text/old.txt"} {
+    HandleError(os.MkdirAll(path.Dir(file), os.ModePerm))
+    HandleError(os.WriteFile(file, []byte("hello"), 0644))
+  }
+
+  data, err := parseConfig([]byte(`
+folders:
+- name: text
+  extensions: [txt, md]
+- name: notes
+  patterns: [notes]
+  folders:
+  - name: work
+    patterns: [work]
+- name: pics
+  extensions: [png]
+`))
+  HandleError(err)
+  report, err := configCoverage(data)
+  HandleError(err)
+
+  // The organized text/old.txt is not a source
+  if report.Files != 3 || report.Unmatched != 1 {
+    t.Errorf("Wrong totals: %+v", report)
+  }
+  coverage := map[string]*folderCoverage{}
+  for _, f := range report.Folders {
+    coverage[f.Folder] = f
+  }
+  if text := coverage["text"]; text.Matched != 2 || text.MatchedBytes != 10 || text.Placed != 2 || text.Matchers[1].Contributed != 0 {
+    t.Errorf("Wrong coverage for text: %+v", text)
+  }
+  if notes := coverage["notes"]; notes.Matched != 2 || notes.Placed != 1 || notes.FirstWins != 0 {
+    t.Errorf("Wrong coverage for notes, it should be shadowed by text: %+v", notes)
+  }
+  if pics := coverage["pics"]; pics.Matched != 0 {
+    t.Errorf("pics should not match anything: %+v", pics)
+  }
+}
+
+func TestRenderPlan(t *testing.T) {
+  plan := Plan{Operations: []Operation{
+    {Src: "b.txt", Dst: "text/b.txt", Action: actionCopy},
+    {Src: "a.txt", Dst: "text/old/a.txt", Action: actionCopy},
+    {Src: "it's.txt", Dst: "notes/it's.txt", Action: actionMove},
+  }}
+
+  var tree bytes.Buffer
+  renderPlanTree(&tree, plan)
+  expected := `├── notes/
+│   └── it's.txt » move
+└── text/
+    ├── old/
+    │   └── a.txt
+    └── b.txt
+`
+  if tree.String() != expected {
+    t.Errorf("Wrong tree:\n%s", tree.String())
+  }
+
+  var csv bytes.Buffer
+  HandleError(renderPlanCSV(&csv, plan))
+  if !strings.HasPrefix(csv.String(), "action,source,destination\ncopy,b.txt,text/b.txt\n") {
+    t.Errorf("Wrong csv:\n%s", csv.String())
+  }
+
+  var script bytes.Buffer
+  HandleError(renderPlanScript(&script, plan, defaultPreserve))
+  if !strings.HasSuffix(script.String(), "mkdir -p 'notes'\nmv -f -- 'it'\\''s.txt' 'notes/it'\\''s.txt'\n") {
+    t.Errorf("Wrong script:\n%s", script.String())
+  }
+}
+
+func TestPreviewPlan(t *testing.T) {
+  dir := chdirTemp(t)
+  HandleError(os.WriteFile("a.txt", []byte("same contents"), 0644))
+  HandleError(os.WriteFile("b.txt", []byte("same contents"), 0644))
+  HandleError(os.WriteFile("c.txt", []byte("other contents"), 0644))
+
+  data, err := parseConfig([]byte("dedupe: skip\nfolders:\n- name: text\n  extensions: [txt]\n"))
+  HandleError(err)
+  state, err := loadState(path.Join(dir, "state.jsonl"))
+  HandleError(err)
+  destinations := func(plan Plan) []string {
+    dsts := []string{}
+    for _, op := range plan.Operations {
+      dsts = append(dsts, op.Dst)
+    }
+    slices.Sort(dsts)
+    return dsts
+  }
+
+  // The duplicate is left out, like applying would
+  plan, skipped, err := previewPlan(data, applyOptions{state: state})
+  HandleError(err)
+  if dsts := destinations(plan); skipped != 0 || !slices.Equal(dsts, []string{"text/a.txt", "text/c.txt"}) {
+    t.Errorf("Preview should deduplicate like applying: %v (%d skipped)", dsts, skipped)
+  }
+
+  // Once applied there is nothing left to do, unless everything is done again
+  all, err := planConfig(data, nil)
+  HandleError(err)
+  HandleError(applyPlan(data, all.Operations, applyOptions{state: state, out: io.Discard}))
+  plan, skipped, err = previewPlan(data, applyOptions{state: state})
+  HandleError(err)
+  if len(plan.Operations) != 0 || skipped != 3 {
+    t.Errorf("Preview should leave out what the state has as organized: %+v (%d skipped)", plan.Operations, skipped)
+  }
+  plan, _, err = previewPlan(data, applyOptions{state: state, full: true})
+  HandleError(err)
+  if dsts := destinations(plan); !slices.Equal(dsts, []string{"text/a.txt", "text/c.txt"}) {
+    t.Errorf("Preview with full should include everything again: %v", dsts)
+  }
+}
+
+func TestReadCandidates(t *testing.T) {
+  dir := chdirTemp(t)
+  HandleError(os.MkdirAll("sub", os.ModePerm))
+  for _, file := range []string{"a.txt", "sub/b.txt", "with\nnewline.txt"} {
+    HandleError(os.WriteFile(file, []byte("hello"), 0644))
+  }
+
+  candidates, err := readCandidates(strings.NewReader("a.txt\n./sub/b.txt\r\n\nmissing.txt\n/etc/hosts\nsub\na.txt\n"), false)
+  HandleError(err)
+  if !slices.Equal(candidates, []string{"a.txt", "sub/b.txt"}) {
+    t.Errorf("Wrong candidates from a newline separated list: %v", candidates)
+  }
+
+  candidates, err = readCandidates(strings.NewReader(path.Join(dir, "sub/b.txt")+"\x00with\nnewline.txt\x00"), true)
+  HandleError(err)
+  if !slices.Equal(candidates, []string{"sub/b.txt", "with\nnewline.txt"}) {
+    t.Errorf("Wrong candidates from a NUL separated list: %v", candidates)
+  }
+}
+
+func TestLivePreviewEvaluation(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.WriteFile("notes.txt", []byte("hello"), 0644))
+  HandleError(os.WriteFile("fileo.yaml", []byte("folders:\n- name: text\n  extensions: [txt]\n"), 0644))
+
+  m := newModel("fileo.yaml")
+  m, cmd := update(m, debounceMsg{editID: m.editID})
+  if !m.evaluating {
+    t.Fatal("The config is not being evaluated")
+  }
+
+  // A newer evaluation makes the result of the first one stale
+  results := runCmd(cmd)
+  m.startEval()
+  for _, msg := range results {
+    m, _ = update(m, msg)
+  }
+  if !m.evaluating || len(m.plan.Operations) != 0 {
+    t.Error("The result of a stale evaluation was used")
+  }
+
+  // Once it is the latest again, its result shows up
+  m.evalID--
+  for _, msg := range results {
+    m, _ = update(m, msg)
+  }
+  if m.evaluating || len(m.plan.Operations) != 1 {
+    t.Fatalf("The evaluation result was not used: %+v", m.plan)
+  }
+  if !slices.ContainsFunc(m.treeItems, func(item treeItem) bool { return item.name == "text" }) {
+    t.Error("The tree was not built from the evaluation")
+  }
+}
+
+func TestLivePreviewDiagnostics(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.WriteFile("notes.txt", []byte("hello"), 0644))
+  HandleError(os.WriteFile("fileo.yaml", []byte("folders:\n- name: text\n  extensions: [txt]\n"), 0644))
+
+  evaluate := func(m model) model {
+    _, cmd := m.Update(debounceMsg{editID: m.editID})
+    m.startEval()
+    for _, msg := range runCmd(cmd) {
+      if result, ok := msg.(evalResultMsg); ok {
+        result.evalID = m.evalID
+        m, _ = update(m, result)
+      }
+    }
+    return m
+  }
+
+  m, _ := update(newModel("fileo.yaml"), tea.WindowSizeMsg{Width: 120, Height: 40})
+  m = evaluate(m)
+  if m.stale || len(m.plan.Operations) != 1 {
+    t.Fatalf("The valid config was not evaluated: %+v", m.plan)
+  }
+
+  m.cfg.SetValue("folders:\n- name: text\n  extensions: [txt]\n  action: zap\n")
+  m = evaluate(m)
+  if !m.stale || len(m.plan.Operations) != 1 {
+    t.Error("The last good result should be kept while the config has errors")
+  }
+  if len(m.diagnostics) != 1 || m.diagnostics[0].line != 4 {
+    t.Fatalf("Wrong diagnostics: %+v", m.diagnostics)
+  }
+  view := m.View()
+  if !strings.Contains(view, "✗ line 4: folder \"text\": unknown action") || !strings.Contains(view, "● ") {
+    t.Errorf("The error is not shown in the preview:\n%s", view)
+  }
+
+  diagnostics := configDiagnostics(errors.New("yaml: unmarshal errors:\n  line 3: cannot unmarshal\n  line 4: cannot unmarshal"))
+  if len(diagnostics) != 2 || diagnostics[1].line != 4 {
+    t.Errorf("Wrong diagnostics for several yaml errors: %+v", diagnostics)
+  }
+}
+
+func TestLivePreviewSaveAndApply(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.WriteFile("notes.txt", []byte("hello"), 0644))
+  HandleError(os.WriteFile("fileo.yaml", []byte("folders:\n- name: text\n  extensions: [md]\n"), 0644))
+
+  m, _ := update(newModel("fileo.yaml"), tea.WindowSizeMsg{Width: 120, Height: 40})
+
+  config := "folders:\n- name: text\n  extensions: [txt]\n"
+  m.cfg.SetValue(config)
+  if !m.modified() {
+    t.Error("The edited config should be marked as modified")
+  }
+  m = press(m, tea.KeyMsg{Type: tea.KeyCtrlS})
+  if saved, _ := os.ReadFile("fileo.yaml"); string(saved) != config || m.modified() {
+    t.Errorf("The config was not saved: %q", saved)
+  }
+
+  // Applying needs an up to date preview
+  m = press(m, tea.KeyMsg{Type: tea.KeyCtrlX})
+  if m.dialog != dialogNone || !m.noticeErr {
+    t.Error("Applying should wait for the preview")
+  }
+  _, cmd := m.Update(debounceMsg{editID: m.editID})
+  m.startEval()
+  for _, msg := range runCmd(cmd) {
+    if result, ok := msg.(evalResultMsg); ok {
+      result.evalID = m.evalID
+      m, _ = update(m, result)
+    }
+  }
+
+  // The dialog says what would be applied once it is worked out
+  m, cmd = update(m, tea.KeyMsg{Type: tea.KeyCtrlX})
+  m = press(m, runes("y"))
+  if m.dialog != dialogApply || !strings.Contains(m.dialogView(), "Working out") {
+    t.Fatalf("The apply dialog should wait for what would be applied:\n%s", m.dialogView())
+  }
+  for _, msg := range runCmd(cmd) {
+    m, _ = update(m, msg)
+  }
+  if view := m.dialogView(); !strings.Contains(view, "copy      1 file") || strings.Contains(view, "skipped") {
+    t.Fatalf("The apply dialog did not open:\n%s", view)
+  }
+  m, cmd = update(m, runes("y"))
+  var evaluate tea.Cmd
+  for _, msg := range runCmd(cmd) {
+    if done, ok := msg.(applyDoneMsg); ok {
+      m, evaluate = update(m, done)
+    }
+  }
+  if m.dialog != dialogApplied || m.applyRun.err != nil {
+    t.Fatalf("The config was not applied: %v", m.applyRun.err)
+  }
+  if _, err := os.Stat("text/notes.txt"); err != nil {
+    t.Error(err)
+  }
+  m = press(m, tea.KeyMsg{Type: tea.KeyEnter})
+
+  // Applying again would skip what the first apply organized, and the dialog says so
+  for _, msg := range runCmd(evaluate) {
+    if result, ok := msg.(evalResultMsg); ok {
+      m, _ = update(m, result)
+    }
+  }
+  m, cmd = update(m, tea.KeyMsg{Type: tea.KeyCtrlX})
+  for _, msg := range runCmd(cmd) {
+    m, _ = update(m, msg)
+  }
+  view := m.dialogView()
+  if !strings.Contains(view, "skipped   1 file") || !strings.Contains(view, "Nothing left to apply") || strings.Contains(view, "copy") {
+    t.Fatalf("The apply dialog should count the file the state skips:\n%s", view)
+  }
+  if m = press(m, runes("y")); m.dialog != dialogApply {
+    t.Error("Nothing should be applied when the state skips everything")
+  }
+  m = press(m, runes("n"))
+
+  // Quitting with unsaved changes asks first
+  m.cfg.SetValue(config + "# more\n")
+  m, cmd = update(m, tea.KeyMsg{Type: tea.KeyCtrlC})
+  if m.dialog != dialogQuit || cmd != nil {
+    t.Fatal("Quitting with unsaved changes did not ask first")
+  }
+  m, cmd = update(m, runes("n"))
+  if _, ok := cmd().(tea.QuitMsg); !ok {
+    t.Error("Quitting without saving did not quit")
+  }
+}
+
+func TestLivePreviewSourceView(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.MkdirAll("inbox", 0755))
+  HandleError(os.WriteFile("inbox/notes.txt", []byte("hello"), 0644))
+
+  m := newModel("fileo.yaml")
+  m.focusedPane = 1
+  m.expandedDirs[m.rootPath] = true
+  m.plan = Plan{Operations: []Operation{
+    {Src: "inbox/notes.txt", Dst: "text/notes.txt", Action: actionCopy},
+    {Src: "inbox/notes.txt", Dst: "backup/notes.txt", Action: actionMove},
+  }}
+  m.expandedDirs[path.Join(m.rootPath, "text")] = true
+  m.buildTree()
+
+  i := slices.IndexFunc(m.treeItems, func(item treeItem) bool { return item.name == "notes.txt" })
+  if i < 0 || m.treeItems[i].source != "inbox/notes.txt" {
+    t.Fatalf("The source was not attached to the file: %+v", m.treeItems)
+  }
+  m.cursor = i
+  if details := m.selectionDetails(80); !strings.Contains(details, "from inbox/notes.txt") {
+    t.Errorf("Wrong details for the selected file: %q", details)
+  }
+
+  m = press(m, runes("v"))
+  m.expandedDirs[path.Join(m.rootPath, "inbox")] = true
+  m.buildTree()
+  i = slices.IndexFunc(m.treeItems, func(item treeItem) bool { return item.name == "notes.txt" })
+  if !m.sourceView || i < 0 || !slices.Equal(m.treeItems[i].destinations, []string{"text/notes.txt", "backup/notes.txt"}) {
+    t.Fatalf("The source view does not list the destinations: %+v", m.treeItems)
+  }
+  if tree := m.renderTree(80, 10); !strings.Contains(tree, "notes.txt → text/notes.txt, backup/notes.txt") {
+    t.Errorf("The destinations are not shown in the tree:\n%s", tree)
+  }
+}
+
+func TestLivePreviewFolderCounts(t *testing.T) {
+  chdirTemp(t)
+  m := newModel("fileo.yaml")
+  m.plan = Plan{
+    Operations: []Operation{
+      {Src: "a.txt", Dst: "docs/text/a.txt"},
+      {Src: "b.pdf", Dst: "docs/b.pdf"},
+    },
+    Unmatched: []string{"c.bin"},
+  }
+  m.folders = []string{"docs", "docs/text", "images"}
+  m.sizes = map[string]int64{"a.txt": 10, "b.pdf": 100, "c.bin": 5}
+  m.expandedDirs[path.Join(m.rootPath, "docs")] = true
+  m.buildTree()
+
+  item := func(name string) treeItem {
+    i := slices.IndexFunc(m.treeItems, func(item treeItem) bool { return item.name == name })
+    if i < 0 {
+      t.Fatalf("%s is not in the tree: %+v", name, m.treeItems)
+    }
+    return m.treeItems[i]
+  }
+  if docs := item("docs"); docs.files != 2 || docs.bytes != 110 {
+    t.Errorf("Wrong count for docs: %d files, %d bytes", docs.files, docs.bytes)
+  }
+  if text := item("text"); text.files != 1 || text.bytes != 10 {
+    t.Errorf("Wrong count for docs/text: %d files, %d bytes", text.files, text.bytes)
+  }
+  if images := item("images"); !images.isDir || images.files != 0 {
+    t.Errorf("The empty folder is not in the tree: %+v", images)
+  }
+  if section := m.treeItems[0]; section.path != unmatchedSectionPath || section.files != 1 || section.bytes != 5 {
+    t.Errorf("The unmatched section is not pinned at the top: %+v", section)
+  }
+
+  tree := m.renderTree(80, 3)
+  if !strings.Contains(tree, "docs/ 2 files, 110 B") || !strings.Contains(tree, "unmatched 1 file, 5 B") {
+    t.Errorf("The counts are not shown:\n%s", tree)
+  }
+  m.cursor = len(m.treeItems) - 1
+  if tree := m.renderTree(80, 3); !strings.Contains(tree, "images/ no files") || !strings.HasPrefix(tree, "▸ unmatched") {
+    t.Errorf("The unmatched section should stay at the top:\n%s", tree)
+  }
+}
+
+func TestLivePreviewDiff(t *testing.T) {
+  before := Plan{Operations: []Operation{
+    {Src: "a.txt", Dst: "text/a.txt"},
+    {Src: "b.md", Dst: "text/b.md"},
+    {Src: "c.pdf", Dst: "pdfs/c.pdf"},
+  }}
+  after := Plan{Operations: []Operation{
+    {Src: "a.txt", Dst: "text/a.txt"},
+    {Src: "b.md", Dst: "docs/b.md"},
+    {Src: "d.png", Dst: "images/d.png"},
+  }}
+  diff := diffPlans(before, after)
+  if diff.sources["a.txt"] != changeNone || diff.sources["b.md"] != changeMoved || diff.sources["c.pdf"] != changeRemoved || diff.sources["d.png"] != changeAdded {
+    t.Errorf("Wrong changes: %v", diff.sources)
+  }
+  if summary := ansi.Strip(diff.summary()); summary != "+1 −1 ~1" {
+    t.Errorf("Wrong summary: %q", summary)
+  }
+
+  chdirTemp(t)
+  m := newModel("fileo.yaml")
+  m.focusedPane = 1
+  m.plan, m.diff = after, diff
+  m.buildTree()
+
+  // Jumping goes through the changes in the order of the tree, opening the folders they are in
+  names := []string{}
+  for range 4 {
+    m = press(m, runes("]"))
+    names = append(names, m.treeItems[m.cursor].name)
+  }
+  if !slices.Equal(names, []string{"b.md", "d.png", "c.pdf", "b.md"}) {
+    t.Errorf("Wrong changes jumped to: %v", names)
+  }
+  m = press(m, runes("["))
+  if item := m.treeItems[m.cursor]; item.name != "c.pdf" || item.change != changeRemoved {
+    t.Errorf("Jumping back did not go to the removed file: %+v", item)
+  }
+}
+
+func TestLivePreviewSearch(t *testing.T) {
+  if !fuzzyMatch("txbt", "text/beta.md") || fuzzyMatch("at", "text/beta.md") {
+    t.Error("Wrong fuzzy matching")
+  }
+
+  chdirTemp(t)
+  m := newModel("fileo.yaml")
+  m.focusedPane = 1
+  m.plan = Plan{
+    Operations: []Operation{
+      {Src: "alpha.txt", Dst: "text/alpha.txt"},
+      {Src: "beta.md", Dst: "text/beta.md"},
+      {Src: "gamma.pdf", Dst: "docs/pdf/gamma.pdf"},
+    },
+    Unmatched: []string{"data.bin"},
+  }
+  m.buildTree()
+
+  selected := func() string { return m.treeItems[m.cursor].name }
+
+  // The first match is revealed while typing
+  m = press(m, runes("/"), runes("g"), runes("a"))
+  if !m.searching || m.query != "ga" || selected() != "gamma.pdf" {
+    t.Fatalf("The search did not jump to the match: %q, %s", m.query, selected())
+  }
+  m = press(m, tea.KeyMsg{Type: tea.KeyEnter})
+  if m.searching || !m.expandedDirs[path.Join(m.rootPath, "docs", "pdf")] {
+    t.Error("The match was not revealed")
+  }
+
+  // n and N go through the matches, the unmatched files included
+  m = press(m, runes("/"), tea.KeyMsg{Type: tea.KeyBackspace}, tea.KeyMsg{Type: tea.KeyBackspace}, runes("a"), tea.KeyMsg{Type: tea.KeyEnter})
+  names := []string{selected()}
+  for range 3 {
+    m = press(m, runes("n"))
+    names = append(names, selected())
+  }
+  m = press(m, runes("N"))
+  names = append(names, selected())
+  if !slices.Equal(names, []string{"data.bin", "alpha.txt", "beta.md", "gamma.pdf", "beta.md"}) {
+    t.Errorf("Wrong matches: %v", names)
+  }
+
+  // The filter only keeps the matches and the folders they are in
+  m = press(m, runes("/"), tea.KeyMsg{Type: tea.KeyBackspace}, runes("p"), runes("d"), tea.KeyMsg{Type: tea.KeyEnter}, runes("f"))
+  visible := []string{}
+  for _, item := range m.treeItems {
+    visible = append(visible, item.name)
+  }
+  if !m.filter || !slices.Equal(visible, []string{path.Base(m.rootPath), "docs", "pdf", "gamma.pdf"}) {
+    t.Errorf("Wrong filtered tree: %v", visible)
+  }
+  m = press(m, tea.KeyMsg{Type: tea.KeyEsc})
+  if m.filter || m.query != "" || len(m.treeItems) <= len(visible) {
+    t.Error("Esc did not clear the search")
+  }
+
+  // Expanding and collapsing everything goes through expandedDirs
+  m = press(m, runes("+"))
+  if !m.expandedDirs[path.Join(m.rootPath, "text")] || !m.expandedDirs[unmatchedSectionPath] || len(m.treeItems) != 9 {
+    t.Errorf("Not everything was expanded: %d items", len(m.treeItems))
+  }
+  m = press(m, runes("-"))
+  if m.expandedDirs[path.Join(m.rootPath, "docs")] || !m.expandedDirs[m.rootPath] || len(m.treeItems) != 4 {
+    t.Errorf("Not everything was collapsed: %d items", len(m.treeItems))
+  }
+}
+
+func TestConfigHighlighting(t *testing.T) {
+  config := "# comment\nfolders:\n- name: 'text' # note\n  patterns:\n    - ^IMG_\\d+\n  extensions: [txt]\n"
+  tokens := highlightConfig(config)
+  lines := strings.Split(config, "\n")
+
+  tokenAt := func(line int, text string) int {
+    return tokens[line][len([]rune(lines[line][:strings.Index(lines[line], text)]))]
+  }
+  checks := []struct {
+    line  int
+    text  string
+    token int
+  }{
+    {0, "comment", tokenComment},
+    {1, "folders", tokenKey},
+    {2, "name", tokenKey},
+    {2, "'text'", tokenString},
+    {2, "# note", tokenComment},
+    {4, "^IMG", tokenRegex},
+    {4, "-", tokenPlain},
+    {5, "txt", tokenPlain},
+  }
+  for _, check := range checks {
+    if token := tokenAt(check.line, check.text); token != check.token {
+      t.Errorf("%q on line %d: expected token %d, got %d", check.text, check.line+1, check.token, token)
+    }
+  }
+}
+
+func TestLivePreviewEditingHelpers(t *testing.T) {
+  chdirTemp(t)
+  m := newModel("fileo.yaml")
+  enter := tea.KeyMsg{Type: tea.KeyEnter}
+  complete := tea.KeyMsg{Type: tea.KeyCtrlAt}
+
+  // New lines follow the nesting of the folders
+  m.cfg.SetValue("folders:")
+  m = press(m, enter)
+  m.cfg.InsertString("name: text")
+  m = press(m, enter)
+  if m.cfg.Value() != "folders:\n  - name: text\n    " {
+    t.Errorf("Wrong indentation: %q", m.cfg.Value())
+  }
+
+  // Keys and the extensions of the files around are completed
+  m.cfg.InsertString("ext")
+  m = press(m, complete)
+  if m.cfg.Value() != "folders:\n  - name: text\n    extensions: " {
+    t.Errorf("The key was not completed: %q", m.cfg.Value())
+  }
+  m.plan = Plan{Unmatched: []string{"a.md", "b.mp3", "c.md"}}
+  m.cfg.InsertString("[m")
+  m = press(m, complete)
+  if !strings.HasSuffix(m.cfg.Value(), "[m") || m.notice != "md mp3" {
+    t.Errorf("The matching extensions were not listed: %q", m.notice)
+  }
+  m.cfg.InsertString("p")
+  m = press(m, complete)
+  if !strings.HasSuffix(m.cfg.Value(), "[mp3") {
+    t.Errorf("The extension was not completed: %q", m.cfg.Value())
+  }
+}
+
+func TestLivePreviewInspector(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.WriteFile("notes.txt", []byte("hello\nworld\n"), 0644))
+  HandleError(os.WriteFile("photo.jpg", []byte{0xff, 0xd8, 0xff, 0xe0, 0, 0x10}, 0644))
+  data, err := parseConfig([]byte("folders:\n- name: text\n  extensions: [txt]\n- name: pdfs\n  extensions: [pdf]\n"))
+  HandleError(err)
+
+  lines := ansi.Strip(strings.Join(inspectFile(data, "notes.txt"), "\n"))
+  for _, want := range []string{"source    notes.txt", "size      12 B", "type      text/plain", "✓ text/  extension txt → text/notes.txt", "✗ pdfs/", "hello\nworld"} {
+    if !strings.Contains(lines, want) {
+      t.Errorf("The inspection is missing %q:\n%s", want, lines)
+    }
+  }
+  if peek := peekLines([]byte{0, 1, 2}, "application/octet-stream"); len(peek) != 1 || !strings.HasPrefix(peek[0], "00000000  00 01 02") {
+    t.Errorf("Binary files should be shown as a hex dump: %q", peek)
+  }
+  cut := []byte(strings.Repeat("a", peekSize-1) + "é")[:peekSize]
+  if peek := peekLines(cut, "text/plain; charset=utf-8"); len(peek) != 1 || peek[0] != strings.Repeat("a", peekSize-1) {
+    t.Errorf("A rune cut at the end of the head should not make the text binary: %q", peek)
+  }
+
+  m := newModel("fileo.yaml")
+  m.focusedPane = 1
+  m.data = data
+  m.plan, err = planConfig(data, []string{"notes.txt", "photo.jpg"})
+  HandleError(err)
+  m.buildTree()
+
+  // The inspection follows the cursor
+  m = press(m, runes("i"))
+  m.setAllExpanded(true)
+  m.cursor = slices.IndexFunc(m.treeItems, func(item treeItem) bool { return item.name == "photo.jpg" })
+  m = press(m, runes("j"))
+  m, cmd := update(m, runes("k"))
+  if !m.inspecting || !strings.Contains(strings.Join(m.inspection, "\n"), "inspecting…") {
+    t.Fatalf("The file should be inspected in the background: %q", m.inspection)
+  }
+  for _, msg := range runCmd(cmd) {
+    m, _ = update(m, msg)
+  }
+  if len(m.inspection) == 0 || !strings.Contains(m.inspection[0], "photo.jpg") {
+    t.Fatalf("The unmatched file is not inspected: %q", m.inspection)
+  }
+  if view := ansi.Strip(m.inspectorView(60, 20)); !strings.Contains(view, "no folder takes it") || !strings.Contains(view, "image/jpeg") {
+    t.Errorf("Wrong inspector:\n%s", view)
+  }
+
+  // Editing the config inspects the file again once the new plan is in
+  m.cfg.SetValue("folders:\n- name: text\n  extensions: [txt]\n- name: docs\n  extensions: [doc]\n")
+  m, cmd = update(m, debounceMsg{editID: m.editID})
+  for _, msg := range runCmd(cmd) {
+    if result, ok := msg.(evalResultMsg); ok {
+      m, cmd = update(m, result)
+    }
+  }
+  for _, msg := range runCmd(cmd) {
+    m, _ = update(m, msg)
+  }
+  if lines := ansi.Strip(strings.Join(m.inspection, "\n")); !strings.Contains(lines, "photo.jpg") || !strings.Contains(lines, "✗ docs/") {
+    t.Errorf("The inspection was not updated for the edited config:\n%s", lines)
+  }
+
+  // The inspection of a file the cursor already left is dropped
+  photo := m.cursor
+  m.cursor = slices.IndexFunc(m.treeItems, func(item treeItem) bool { return item.name == "notes.txt" })
+  m, stale := update(m, tea.WindowSizeMsg{Width: 120, Height: 40})
+  m.cursor = photo
+  m, cmd = update(m, tea.WindowSizeMsg{Width: 120, Height: 40})
+  m, _ = update(m, runCmd(cmd)[0])
+  m, _ = update(m, runCmd(stale)[0])
+  if lines := strings.Join(m.inspection, "\n"); !strings.Contains(lines, "photo.jpg") {
+    t.Errorf("A stale inspection replaced the current one:\n%s", lines)
+  }
+  m = press(m, runes("i"))
+  if m.inspecting {
+    t.Error("The inspector should close")
+  }
+}
+
+func TestLivePreviewReload(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.WriteFile("fileo.yaml", []byte("folders:\n- name: text\n  extensions: [txt]\n"), 0644))
+  m := newModel("fileo.yaml")
+
+  // Our own save is not a change
+  if cmd := m.reloadConfig(); cmd != nil || m.notice != "" {
+    t.Errorf("Reloading an unchanged config should do nothing: %q", m.notice)
+  }
+
+  changed := "folders:\n- name: docs\n  extensions: [md]\n"
+  HandleError(os.WriteFile("fileo.yaml", []byte(changed), 0644))
+  if cmd := m.reloadConfig(); cmd == nil || m.cfg.Value() != changed || m.modified() {
+    t.Fatalf("The config was not reloaded: %q", m.cfg.Value())
+  }
+
+  // Unsaved edits are kept
+  m.cfg.InsertString("# mine\n")
+  HandleError(os.WriteFile("fileo.yaml", []byte("folders: []\n"), 0644))
+  m.reloadConfig()
+  if !strings.Contains(m.cfg.Value(), "# mine") || !m.noticeErr {
+    t.Errorf("The unsaved changes were replaced: %q", m.cfg.Value())
+  }
+
+  // Saving them asks before overwriting the change on disk
+  m = press(m, tea.KeyMsg{Type: tea.KeyCtrlS})
+  if saved, _ := os.ReadFile("fileo.yaml"); m.dialog != dialogConflict || string(saved) != "folders: []\n" {
+    t.Fatalf("The change on disk was overwritten: %q", saved)
+  }
+  m = press(m, tea.KeyMsg{Type: tea.KeyEsc}, tea.KeyMsg{Type: tea.KeyCtrlS}, runes("l"))
+  if m.dialog != dialogNone || m.cfg.Value() != "folders: []\n" || m.modified() {
+    t.Errorf("The config on disk was not loaded: %q", m.cfg.Value())
+  }
+
+  // The same when quitting, overwriting it quits
+  m.cfg.InsertString("# mine\n")
+  HandleError(os.WriteFile("fileo.yaml", []byte(changed), 0644))
+  m = press(m, tea.KeyMsg{Type: tea.KeyCtrlC}, runes("y"))
+  if m.dialog != dialogConflict {
+    t.Fatal("Saving before quitting did not ask about the change on disk")
+  }
+  m, cmd := update(m, runes("o"))
+  if saved, _ := os.ReadFile("fileo.yaml"); string(saved) != m.cfg.Value() || cmd == nil {
+    t.Errorf("The config was not overwritten: %q", saved)
+  } else if _, ok := cmd().(tea.QuitMsg); !ok {
+    t.Error("Overwriting the config did not quit")
+  }
+  if m = press(m, tea.KeyMsg{Type: tea.KeyCtrlS}); m.dialog != dialogNone {
+    t.Error("Our own save should not be a conflict")
+  }
+
+  t.Setenv("EDITOR", "")
+  if cmd := m.openEditor(); cmd != nil || !strings.Contains(m.notice, "$EDITOR") {
+    t.Errorf("Opening the editor without $EDITOR should tell so: %q", m.notice)
+  }
+
+  if err := m.watchConfig(); err != nil {
+    t.Skip("No watcher here:", err)
+  }
+  defer m.watcher.Close()
+  changes := make(chan tea.Msg)
+  go func() { changes <- m.waitForConfigChange()() }()
+  HandleError(os.WriteFile("other.txt", []byte("x"), 0644))
+
+  // Nothing is reloaded while the file is still being written
+  f, err := os.OpenFile("fileo.yaml", os.O_WRONLY|os.O_TRUNC, 0644)
+  HandleError(err)
+  _, err = f.WriteString(changed[:5])
+  HandleError(err)
+  select {
+  case msg := <-changes:
+    t.Errorf("The config was reloaded before it was written: %#v", msg)
+  case <-time.After(200 * time.Millisecond):
+  }
+  _, err = f.WriteString(changed[5:])
+  HandleError(err)
+  HandleError(f.Close())
+  select {
+  case msg := <-changes:
+    if _, ok := msg.(configChangedMsg); !ok {
+      t.Errorf("Wrong message for a change of the config: %#v", msg)
+    }
+  case <-time.After(5 * time.Second):
+    t.Error("The change of the config was not noticed")
+  }
+}
+
+func TestPreserveAttributes(t *testing.T) {
+  dir := t.TempDir()
+  src := path.Join(dir, "script.sh")
+  err := os.WriteFile(src, []byte("echo hi"), 0755)
+  HandleError(err)
+
+  modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+  err = os.Chtimes(src, modTime, modTime)
+  HandleError(err)
+
+  outDir := path.Join(dir, "out")
+  copyMatchedFiles([]string{src}, outDir, defaultPreserve)
+
+  info, err := os.Stat(path.Join(outDir, "script.sh"))
+  if err != nil {
+    t.Fatal("Preserve failed. Copied file does not exist")
+  }
+  if info.Mode().Perm() != 0755 {
+    t.Errorf("Preserve failed. Mode was not kept: %v", info.Mode().Perm())
+  }
+  if !info.ModTime().Equal(modTime) {
+    t.Errorf("Preserve failed. Modification time was not kept: %v", info.ModTime())
+  }
+
+  if _, err := parsePreserve([]string{"mode", "colour"}); err == nil {
+    t.Error("parsePreserve should fail on unknown attributes")
+  }
+  if p, _ := parsePreserve([]string{"all"}); !p.Owner || !p.Xattrs {
+    t.Error("parsePreserve did not enable everything for 'all'")
+  }
+}
+
+
+// helper function, runs a tea command (and the batches it returns) and collects the messages
+func runCmd(cmd tea.Cmd) []tea.Msg {
+  if cmd == nil {
+    return nil
+  }
+  msg := cmd()
+  if batch, ok := msg.(tea.BatchMsg); ok {
+    msgs := []tea.Msg{}
+    for _, cmd := range batch {
+      msgs = append(msgs, runCmd(cmd)...)
+    }
+    return msgs
+  }
+  return []tea.Msg{msg}
+}
+
+// helper function, hands a message to the live preview and keeps the updated model
+func update(m model, msg tea.Msg) (model, tea.Cmd) {
+  updated, cmd := m.Update(msg)
+  return updated.(model), cmd
+}
+
+// helper function, sends key presses to the live preview one after the other
+func press(m model, keys ...tea.KeyMsg) model {
+  for _, key := range keys {
+    m, _ = update(m, key)
+  }
+  return m
+}
+
+// helper function, the key press typing s
+func runes(s string) tea.KeyMsg {
+  return tea.KeyMsg{Type: tea.KeyRunes, Runes: []rune(s)}
+}
+
+// helper function, runs the rest of the test in a new empty directory
+func chdirTemp(t *testing.T) string {
+  dir := t.TempDir()
+  wd, err := os.Getwd()
+  HandleError(err)
+  HandleError(os.Chdir(dir))
+  t.Cleanup(func() { os.Chdir(wd) })
+  return dir
+}
+
 // helper function, checks if a folder/file exists
 func pathExists(t *testing.T, pathName string) {
   if _, err := os.Stat(path.Join(tempDir, pathName)); err != nil {
@@ -243,6 +1554,28 @@ func pathExists(t *testing.T, pathName string) {
 
 
 
+func TestCommandLineExtensions(t *testing.T) {
+  chdirTemp(t)
+  HandleError(os.MkdirAll("sub", 0755))
+  HandleError(os.WriteFile("a.txt", []byte("a"), 0644))
+  HandleError(os.WriteFile("sub/b.txt", []byte("b"), 0644))
+
+  // -e used to be read from a flag that does not exist, and -r was the wrong way around
+  HandleError(newApp().Run([]string{"fileo", "-e", "txt", "-o", "out"}))
+  if _, err := os.Stat("out/a.txt"); err != nil {
+    t.Errorf("-e did not organize the matching file: %v", err)
+  }
+  if _, err := os.Stat("out/b.txt"); err == nil {
+    t.Error("-e should leave the sub directories alone without -r")
+  }
+
+  HandleError(os.RemoveAll("out"))
+  HandleError(newApp().Run([]string{"fileo", "-e", "txt", "-r", "-o", "out"}))
+  if _, err := os.Stat("out/b.txt"); err != nil {
+    t.Errorf("-e -r did not organize the file in the sub directory: %v", err)
+  }
+}
+
 // NOT yet implemented
 func TestMovefile(t *testing.T) {
 }
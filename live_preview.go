@@ -1,20 +1,39 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"path"
 	"path/filepath"
+	"regexp"
+	"slices"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/charmbracelet/bubbles/help"
 	"github.com/charmbracelet/bubbles/key"
+	"github.com/charmbracelet/bubbles/progress"
+	"github.com/charmbracelet/bubbles/spinner"
 	"github.com/charmbracelet/bubbles/textarea"
+	"github.com/charmbracelet/bubbles/textinput"
 	tea "github.com/charmbracelet/bubbletea"
 	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
 )
 
 const (
 	helpHeight = 5
+
+	// The config is evaluated again once nothing was typed for this long
+	evalDebounce = 300 * time.Millisecond
+
+	// At most this many config errors are listed below the config
+	maxDiagnostics = 3
+
+	// Width of the prompt the textarea draws before the line numbers, View puts the error markers there
+	gutterWidth = 2
 )
 
 var (
@@ -39,15 +58,43 @@ var (
 
 	blurredBorderStyle = lipgloss.NewStyle().
 				Border(lipgloss.HiddenBorder())
+
+	unmatchedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("203"))
+
+	statusStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("241"))
+
+	errorStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("196"))
+
+	// The tree of the last good config while the current one has errors
+	staleStyle = lipgloss.NewStyle().Faint(true)
+
+	// Folders of the config that get no files
+	emptyStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+
+	// Files that are not plain copies get marked in the tree
+	actionStyles = map[string]lipgloss.Style{
+		actionMove:     lipgloss.NewStyle().Foreground(lipgloss.Color("214")),
+		actionSymlink:  lipgloss.NewStyle().Foreground(lipgloss.Color("45")),
+		actionHardlink: lipgloss.NewStyle().Foreground(lipgloss.Color("141")),
+		actionReflink:  lipgloss.NewStyle().Foreground(lipgloss.Color("78")),
+	}
+	actionMarkers = map[string]string{
+		actionMove:     " » move",
+		actionSymlink:  " → symlink",
+		actionHardlink: " ⇔ hardlink",
+		actionReflink:  " ≈ reflink",
+	}
 )
 
 type keymap = struct {
-	switchPanel, refresh, quit, up, down, toggle key.Binding
+	switchPanel, refresh, quit, up, down, toggle, sourceView, inspector, nextChange, prevChange key.Binding
+	search, nextMatch, prevMatch, filter, clearSearch, expandAll, collapseAll                   key.Binding
+	complete, save, apply, editor                                                               key.Binding
 }
 
 func newTextarea() textarea.Model {
 	t := textarea.New()
-	t.Prompt = ""
+	t.Prompt = strings.Repeat(" ", gutterWidth)
 	t.Placeholder = "Type something"
 	t.ShowLineNumbers = true
 	t.Cursor.Style = cursorStyle
@@ -73,8 +120,22 @@ type treeItem struct {
 	expanded bool
 	depth    int
 	children []treeItem
+	action   string // how the file gets here (copy, move, symlink...), only set for files
+	unmatch  bool   // part of the unmatched section instead of the destination tree
+
+	source       string   // file this one is organized from, only set for files of the destination view
+	destinations []string // where the file is organized to, only set for files of the source view
+
+	files int   // files ending up in a directory (recursively), or 1 for a file
+	bytes int64 // their total size
+
+	change int // how the file changed since the previous evaluation
 }
 
+// The unmatched section is not a real directory, this is the key used for its expanded state.
+// It is pinned to the top of the tree.
+const unmatchedSectionPath = "\x00unmatched"
+
 type model struct {
 	width        int
 	height       int
@@ -85,10 +146,104 @@ type model struct {
 	treeItemRoot treeItem
 	cursor       int // selected item in tree
 	leftWidth    int
-	focusedPane  int // 0 = left (config), 1 = right (tree)
+	focusedPane  int  // 0 = left (config), 1 = right (tree)
+	sourceView   bool // the tree shows the files as they are now, annotated with their destinations
 	rootPath     string
 	expandedDirs map[string]bool // tracks which dirs are expanded
 	cfgFilePath  string
+
+	// The config is evaluated in the background, the tree shows the result of the last evaluation
+	data       ConfigData
+	plan       Plan
+	folders    []string
+	sizes      map[string]int64
+	diff       planDiff // changes of the plan since the previous evaluation
+	lastValue  string   // config text as of the last edit we noticed
+	editID     int      // bumped on every edit, only the last one gets evaluated after the debounce
+	evalID     int      // the running evaluation, results of older ones are dropped
+	cancelEval context.CancelFunc
+	evaluating bool
+	evalTook   time.Duration
+	spinner    spinner.Model
+
+	// Searching the tree, the filter only shows what matches
+	search    textinput.Model
+	searching bool // the search is being typed
+	query     string
+	filter    bool
+
+	// The inspector shows the details of the selected file
+	inspecting bool
+	inspected  string // the selected file (and plan) the inspection is for
+	inspection []string
+
+	// Problems with the config as it is typed right now. The tree keeps showing the last good
+	// config (stale) until they are fixed.
+	diagnostics []configDiagnostic
+	stale       bool
+	planValue   string // config text the plan is for
+	planID      int    // bumped whenever the result of an evaluation replaces the plan
+
+	savedValue string // config text as it is in cfgFilePath
+	diskValue  string // contents of cfgFilePath when it was loaded or saved, to notice changes by others
+	diskChange string // contents of cfgFilePath changed by others while there were unsaved changes
+	quitSaved  bool   // quit once the conflict dialog saved the config
+	notice     string // result of the last save or apply request, until the next edit
+	noticeErr  bool
+	dialog     int
+	applyPlan  *applyPlanMsg // what the apply dialog would run, nil while it is worked out
+	applyRun   *applyRun
+	applyBar   progress.Model
+	watcher    fileWatcher // reloads the config when its file changes, nil when not watching
+}
+
+// A problem with the config, line is 0 when it is not about a specific line
+type configDiagnostic struct {
+	line    int
+	message string
+}
+
+var diagnosticLineRegex = regexp.MustCompile(`^(?:yaml: )?line (\d+): (.*)$`)
+
+// Splits a config error into its problems. The yaml errors and our own validation errors start
+// with the line they are about, yaml can also report several problems at once (one per line).
+func configDiagnostics(err error) []configDiagnostic {
+	diagnostics := []configDiagnostic{}
+	for _, text := range strings.Split(err.Error(), "\n") {
+		text = strings.TrimSpace(text)
+		if text == "" || text == "yaml: unmarshal errors:" {
+			continue
+		}
+		if match := diagnosticLineRegex.FindStringSubmatch(text); match != nil {
+			line, _ := strconv.Atoi(match[1])
+			diagnostics = append(diagnostics, configDiagnostic{line: line, message: match[2]})
+		} else {
+			diagnostics = append(diagnostics, configDiagnostic{message: strings.TrimPrefix(text, "yaml: ")})
+		}
+	}
+	return diagnostics
+}
+
+// Sent once the debounce after an edit is over
+type debounceMsg struct {
+	editID int
+}
+
+// What the config does to the current directory
+type evaluation struct {
+	data    ConfigData
+	plan    Plan
+	folders []string         // paths of all the folders of the config, also the ones getting no files
+	sizes   map[string]int64 // size of the files the plan reads and of the unmatched ones
+}
+
+// Result of evaluating the config in the background
+type evalResultMsg struct {
+	evaluation
+	evalID int
+	value  string
+	err    error
+	took   time.Duration
 }
 
 func newModel(cfgFilePath string) model {
@@ -97,6 +252,9 @@ func newModel(cfgFilePath string) model {
 		help:         help.New(),
 		focusedPane:  0, // start with config panel focused
 		expandedDirs: make(map[string]bool),
+		spinner:      spinner.New(spinner.WithSpinner(spinner.MiniDot), spinner.WithStyle(statusStyle)),
+		applyBar:     progress.New(progress.WithDefaultGradient(), progress.WithWidth(40)),
+		search:       newSearchInput(),
 		keymap: keymap{
 			switchPanel: key.NewBinding(
 				key.WithKeys("tab"),
@@ -106,6 +264,54 @@ func newModel(cfgFilePath string) model {
 				key.WithKeys("ctrl+r"),
 				key.WithHelp("ctrl+r", "refresh"),
 			),
+			nextChange: key.NewBinding(
+				key.WithKeys("]"),
+				key.WithHelp("]/[", "next/previous change"),
+			),
+			prevChange: key.NewBinding(
+				key.WithKeys("["),
+			),
+			search: key.NewBinding(
+				key.WithKeys("/"),
+				key.WithHelp("/", "search"),
+			),
+			nextMatch: key.NewBinding(
+				key.WithKeys("n"),
+				key.WithHelp("n/N", "next/previous match"),
+			),
+			prevMatch: key.NewBinding(
+				key.WithKeys("N"),
+			),
+			filter: key.NewBinding(
+				key.WithKeys("f"),
+				key.WithHelp("f", "filter"),
+			),
+			clearSearch: key.NewBinding(
+				key.WithKeys("esc"),
+			),
+			expandAll: key.NewBinding(
+				key.WithKeys("+"),
+				key.WithHelp("+/-", "expand/collapse all"),
+			),
+			collapseAll: key.NewBinding(
+				key.WithKeys("-"),
+			),
+			complete: key.NewBinding(
+				key.WithKeys("ctrl+@"),
+				key.WithHelp("ctrl+space", "complete"),
+			),
+			save: key.NewBinding(
+				key.WithKeys("ctrl+s"),
+				key.WithHelp("ctrl+s", "save"),
+			),
+			apply: key.NewBinding(
+				key.WithKeys("ctrl+x"),
+				key.WithHelp("ctrl+x", "apply"),
+			),
+			editor: key.NewBinding(
+				key.WithKeys("ctrl+o"),
+				key.WithHelp("ctrl+o", "edit in $EDITOR"),
+			),
 			up: key.NewBinding(
 				key.WithKeys("up", "k"),
 				key.WithHelp("↑/k", "up"),
@@ -118,6 +324,14 @@ func newModel(cfgFilePath string) model {
 				key.WithKeys("enter", " "),
 				key.WithHelp("enter/space", "expand/collapse"),
 			),
+			sourceView: key.NewBinding(
+				key.WithKeys("v"),
+				key.WithHelp("v", "source view"),
+			),
+			inspector: key.NewBinding(
+				key.WithKeys("i"),
+				key.WithHelp("i", "inspector"),
+			),
 			quit: key.NewBinding(
 				key.WithKeys("q", "ctrl+c"),
 				key.WithHelp("q", "quit"),
@@ -137,12 +351,15 @@ func newModel(cfgFilePath string) model {
 		m.cfg.SetValue("could not read config file, make sure it exists")
 	} else {
 		m.cfg.SetValue(string(data))
+		m.diskValue = string(data)
 
 		// Temporary fix -- TODO: figure out a better way to do this
 		for range 100 {
 			m.cfg.CursorUp()
 		}
 	}
+	m.lastValue = m.cfg.Value()
+	m.savedValue = m.cfg.Value()
 
 	// initial tree from cwd
 	if wd, err := os.Getwd(); err == nil {
@@ -157,18 +374,121 @@ func newModel(cfgFilePath string) model {
 }
 
 func (m model) Init() tea.Cmd {
-	return textarea.Blink
+	// Evaluate the config right away, without waiting for an edit
+	evaluateNow := func() tea.Msg { return debounceMsg{editID: m.editID} }
+	return tea.Batch(textarea.Blink, evaluateNow, m.waitForConfigChange())
+}
+
+// Starts evaluating the current config in the background, cancelling the one still running
+func (m *model) startEval() tea.Cmd {
+	if m.cancelEval != nil {
+		m.cancelEval()
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	m.cancelEval = cancel
+	m.evalID++
+	m.evaluating = true
+
+	evalID, yamlFile, configFile := m.evalID, m.cfg.Value(), m.cfgFilePath
+	evaluate := func() tea.Msg {
+		start := time.Now()
+		result, err := evaluateConfig(ctx, []byte(yamlFile), configFile)
+		return evalResultMsg{evaluation: result, evalID: evalID, value: yamlFile, err: err, took: time.Since(start)}
+	}
+	return tea.Batch(evaluate, m.spinner.Tick)
+}
+
+// Works out what applying the config would do, giving up early once ctx is cancelled
+func evaluateConfig(ctx context.Context, yamlFile []byte, configFile string) (evaluation, error) {
+	data, err := parseConfig(yamlFile)
+	if err != nil {
+		return evaluation{}, err
+	}
+	data.configFile = candidatePath(configFile)
+	candidates, err := listCandidatesContext(ctx)
+	if err != nil {
+		return evaluation{}, err
+	}
+	plan, err := planConfig(data, candidates)
+	if err != nil {
+		return evaluation{}, err
+	}
+
+	result := evaluation{data: data, plan: plan, folders: configFolders("", data.Folders), sizes: map[string]int64{}}
+	files := slices.Clone(plan.Unmatched)
+	for _, op := range plan.Operations {
+		files = append(files, op.Src)
+	}
+	for _, file := range files {
+		if ctx.Err() != nil {
+			return evaluation{}, ctx.Err()
+		}
+		if _, ok := result.sizes[file]; ok {
+			continue
+		}
+		if info, err := os.Stat(file); err == nil {
+			result.sizes[file] = info.Size()
+		}
+	}
+	return result, nil
+}
+
+// Paths of the folders of a config and all their sub-folders
+func configFolders(parentDir string, folders []Folder) []string {
+	paths := []string{}
+	for _, folder := range folders {
+		folderPath := path.Join(parentDir, folder.Name)
+		paths = append(paths, folderPath)
+		paths = append(paths, configFolders(folderPath, folder.ChildFolders)...)
+	}
+	return paths
 }
 
 func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	updated, cmd := m.update(msg)
+	if m, ok := updated.(model); ok {
+		return m, tea.Batch(cmd, m.inspect())
+	}
+	return updated, cmd
+}
+
+func (m model) update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	var cmds []tea.Cmd
 
 	switch msg := msg.(type) {
 	case tea.KeyMsg:
+		if m.dialog != dialogNone {
+			return m.updateDialog(msg)
+		}
+		if m.searching {
+			return m.updateSearch(msg)
+		}
+
 		switch {
 
-		case key.Matches(msg, m.keymap.quit):
-			return m, tea.Quit
+		// q is just a letter while typing the config
+		case key.Matches(msg, m.keymap.quit) && (m.focusedPane == 1 || msg.String() != "q"):
+			if m.modified() {
+				m.dialog = dialogQuit
+				return m, nil
+			}
+			return m, m.quit()
+
+		case key.Matches(msg, m.keymap.save):
+			m.save()
+			return m, nil
+
+		case key.Matches(msg, m.keymap.apply):
+			return m, m.confirmApply()
+
+		case key.Matches(msg, m.keymap.editor):
+			return m, m.openEditor()
+
+		case key.Matches(msg, m.keymap.complete):
+			if m.focusedPane == 0 {
+				m.complete()
+				return m, m.edited()
+			}
 
 		case key.Matches(msg, m.keymap.switchPanel):
 			m.focusedPane = 1 - m.focusedPane // toggle 0<->1
@@ -180,8 +500,7 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m, nil
 
 		case key.Matches(msg, m.keymap.refresh):
-			m.buildTree()
-			return m, nil
+			return m, m.startEval()
 
 		case key.Matches(msg, m.keymap.up):
 			if m.focusedPane == 1 && len(m.treeItems) > 0 {
@@ -212,10 +531,157 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				}
 				return m, nil
 			}
+		case key.Matches(msg, m.keymap.nextChange, m.keymap.prevChange):
+			if m.focusedPane == 1 {
+				step := 1
+				if key.Matches(msg, m.keymap.prevChange) {
+					step = -1
+				}
+				m.jumpToChange(step)
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.search):
+			if m.focusedPane == 1 {
+				m.searching = true
+				m.search.SetValue(m.query)
+				return m, m.search.Focus()
+			}
+		case key.Matches(msg, m.keymap.nextMatch, m.keymap.prevMatch):
+			if m.focusedPane == 1 && m.query != "" {
+				step := 1
+				if key.Matches(msg, m.keymap.prevMatch) {
+					step = -1
+				}
+				if !m.jumpTo(step, false, m.matchesSearch) {
+					m.notice, m.noticeErr = "nothing matches /"+m.query, true
+				}
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.filter):
+			if m.focusedPane == 1 {
+				if m.query == "" {
+					m.notice, m.noticeErr = "search with / first to filter the tree", true
+					return m, nil
+				}
+				m.filter = !m.filter
+				if m.filter {
+					m.revealMatches()
+				}
+				m.buildTree()
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.clearSearch):
+			if m.focusedPane == 1 && m.query != "" {
+				m.setQuery("")
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.expandAll, m.keymap.collapseAll):
+			if m.focusedPane == 1 {
+				m.setAllExpanded(key.Matches(msg, m.keymap.expandAll))
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.sourceView):
+			if m.focusedPane == 1 {
+				m.sourceView = !m.sourceView
+				m.cursor = 0
+				m.buildTree()
+				return m, nil
+			}
+		case key.Matches(msg, m.keymap.inspector):
+			if m.focusedPane == 1 {
+				m.inspecting = !m.inspecting
+				m.inspected = ""
+				return m, nil
+			}
 		}
 	case tea.WindowSizeMsg:
 		m.height = msg.Height
 		m.width = msg.Width
+		m.help.Width = msg.Width
+
+	case debounceMsg:
+		// Only evaluate when nothing else was typed in the meantime
+		if msg.editID == m.editID {
+			return m, m.startEval()
+		}
+		return m, nil
+
+	case evalResultMsg:
+		if msg.evalID != m.evalID {
+			return m, nil
+		}
+		m.evaluating = false
+		m.evalTook = msg.took
+		if msg.err != nil {
+			// Keep showing the last good tree
+			m.diagnostics = configDiagnostics(msg.err)
+			m.stale = true
+			m.sizeInputs()
+			return m, nil
+		}
+		m.diagnostics = nil
+		m.stale = false
+		m.sizeInputs()
+		// There is nothing to compare the first evaluation with
+		if m.planValue != "" {
+			m.diff = diffPlans(m.plan, msg.plan)
+		}
+		m.data, m.plan, m.folders, m.sizes = msg.data, msg.plan, msg.folders, msg.sizes
+		m.planValue = msg.value
+		m.planID++
+		m.buildTree()
+		return m, nil
+
+	case configChangedMsg:
+		return m, tea.Batch(m.reloadConfig(), m.waitForConfigChange())
+
+	case configWatchErrMsg:
+		m.notice, m.noticeErr = fmt.Sprintf("watching the config: %v", msg.err), true
+		return m, m.waitForConfigChange()
+
+	case editorDoneMsg:
+		if msg.err != nil {
+			m.notice, m.noticeErr = fmt.Sprintf("editor: %v", msg.err), true
+			return m, nil
+		}
+		return m, m.reloadConfig()
+
+	case inspectResultMsg:
+		// The selection could have moved on in the meantime
+		if msg.key == m.inspected {
+			m.inspection = msg.lines
+		}
+		return m, nil
+
+	case applyPlanMsg:
+		// The dialog could have been closed (and the plan replaced) in the meantime
+		if m.dialog == dialogApply && msg.planID == m.planID {
+			m.applyPlan = &msg
+		}
+		return m, nil
+
+	case applyTickMsg:
+		// Redraws the progress while applying
+		if m.dialog == dialogApplying {
+			return m, applyTick()
+		}
+		return m, nil
+
+	case applyDoneMsg:
+		m.dialog = dialogApplied
+		m.applyRun.err = msg.err
+		m.applyRun.took = msg.took
+		// Files were moved around, so the preview has to be worked out again
+		return m, m.startEval()
+
+	case spinner.TickMsg:
+		// The spinner stops ticking once the evaluation is done
+		if m.evaluating {
+			var cmd tea.Cmd
+			m.spinner, cmd = m.spinner.Update(msg)
+			return m, cmd
+		}
+		return m, nil
 	}
 
 	m.sizeInputs()
@@ -225,19 +691,39 @@ func (m model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		newCfg, cmd := m.cfg.Update(msg)
 		m.cfg = newCfg
 		cmds = append(cmds, cmd)
+
+		if msg, ok := msg.(tea.KeyMsg); ok && key.Matches(msg, m.cfg.KeyMap.InsertNewline) {
+			m.autoIndent()
+		}
+		if m.cfg.Value() != m.lastValue {
+			m.notice = ""
+		}
+		cmds = append(cmds, m.edited())
 	}
 
 	// Tehcnically, this just has atmost 1 element but makes it easy in the future if we want to batch commands
 	return m, tea.Batch(cmds...)
 }
 
+// Edits are evaluated once the typing stops for a moment
+func (m *model) edited() tea.Cmd {
+	value := m.cfg.Value()
+	if value == m.lastValue {
+		return nil
+	}
+	m.lastValue = value
+	m.editID++
+	editID := m.editID
+	return tea.Tick(evalDebounce, func(time.Time) tea.Msg { return debounceMsg{editID: editID} })
+}
+
 func (m *model) sizeInputs() {
 	left := m.width / 2
 	m.leftWidth = left
 
 	// Set textarea size (accounting for border and padding)
 	textAreaWidth := left - 2
-	textAreaHeight := m.height - helpHeight - 4
+	textAreaHeight := m.height - helpHeight - 5 - m.diagnosticsHeight()
 
 	m.cfg.SetWidth(textAreaWidth)
 	m.cfg.SetHeight(textAreaHeight)
@@ -249,7 +735,18 @@ func (m model) View() string {
 		m.keymap.up,
 		m.keymap.down,
 		m.keymap.toggle,
+		m.keymap.sourceView,
+		m.keymap.inspector,
+		m.keymap.search,
+		m.keymap.nextMatch,
+		m.keymap.filter,
+		m.keymap.expandAll,
+		m.keymap.nextChange,
 		m.keymap.refresh,
+		m.keymap.complete,
+		m.keymap.save,
+		m.keymap.apply,
+		m.keymap.editor,
 		m.keymap.quit,
 	})
 
@@ -270,7 +767,7 @@ func (m model) View() string {
 		BorderForeground(leftBorderColor).
 		Padding(0, 1)
 
-	leftPanel := leftStyle.Render(m.cfg.View())
+	leftPanel := leftStyle.Render(m.configHeader(leftWidth-2) + "\n" + m.markErrorLines(m.highlightView(m.cfg.View())) + m.diagnosticsView(leftWidth-2))
 
 	// Right panel with tree
 	rightBorderColor := lipgloss.Color("240")
@@ -284,16 +781,147 @@ func (m model) View() string {
 		BorderForeground(rightBorderColor).
 		Padding(0, 1)
 
-	treeView := m.renderTree(previewWidth-4, panelHeight-2)
+	// The details of the selected item stay at the bottom of the panel
+	treeHeight := panelHeight - 2
+	inspector := ""
+	if m.inspecting {
+		inspectorHeight := treeHeight / 2
+		treeHeight -= inspectorHeight
+		inspector = m.inspectorView(previewWidth-4, inspectorHeight)
+	}
+	tree := m.renderTree(previewWidth-4, treeHeight)
+	tree += strings.Repeat("\n", max(treeHeight-strings.Count(tree, "\n"), 0)) + inspector
+	footer := m.selectionDetails(previewWidth - 4)
+	if m.searching {
+		m.search.Width = previewWidth - 6
+		footer = m.search.View()
+	}
+	treeView := ansi.Truncate(m.statusLine(), previewWidth-4, "…") + "\n" + tree + footer
 	rightPanel := rightStyle.Render(treeView)
 
 	body := lipgloss.JoinHorizontal(lipgloss.Top, leftPanel, rightPanel)
+	if m.dialog != dialogNone {
+		body = lipgloss.Place(m.width, lipgloss.Height(body), lipgloss.Center, lipgloss.Center, m.dialogView())
+	}
 
 	return body + "\n" + helpView
 }
 
-func RunLivePreview(previewConfig string) {
-	if _, err := tea.NewProgram(newModel(previewConfig), tea.WithAltScreen()).Run(); err != nil {
+// Shows whether the config is being evaluated, or how long the last evaluation took
+func (m model) statusLine() string {
+	if m.evaluating {
+		return m.spinner.View() + statusStyle.Render(" evaluating…")
+	}
+	if m.stale {
+		return errorStyle.Render("✗ config has errors, showing the last good result")
+	}
+	if m.evalTook == 0 {
+		return ""
+	}
+	took := m.evalTook.Round(100 * time.Microsecond)
+	if took >= time.Second {
+		took = took.Round(10 * time.Millisecond)
+	}
+	status := statusStyle.Render(fmt.Sprintf("evaluated in %s", took))
+	if summary := m.diff.summary(); summary != "" {
+		status += statusStyle.Render("  changes: ") + summary
+	}
+	if search := m.searchStatus(); search != "" {
+		status += "  " + search
+	}
+	return status
+}
+
+// Lines taken by the list of config problems below the textarea
+func (m model) diagnosticsHeight() int {
+	if len(m.diagnostics) == 0 {
+		return 0
+	}
+	return min(len(m.diagnostics), maxDiagnostics+1) + 1
+}
+
+func (m model) diagnosticsView(width int) string {
+	if len(m.diagnostics) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("\n")
+	for i, d := range m.diagnostics {
+		if i == maxDiagnostics && len(m.diagnostics) > maxDiagnostics+1 {
+			b.WriteString("\n" + statusStyle.Render(fmt.Sprintf("… and %d more", len(m.diagnostics)-i)))
+			break
+		}
+		text := "✗ " + d.message
+		if d.line > 0 {
+			text = fmt.Sprintf("✗ line %d: %s", d.line, d.message)
+		}
+		b.WriteString("\n" + errorStyle.Render(ansi.Truncate(text, width, "…")))
+	}
+	return b.String()
+}
+
+// How the view of the textarea is laid out: the rows between its top and bottom border show the
+// config, and each of them starts at column with the prompt, followed by the line number on the
+// first row of each line (wrapped rows have no number).
+type textareaLayout struct {
+	first, end  int // the rows showing the config are rows[first:end]
+	column      int
+	numberWidth int
+}
+
+func (m model) textareaLayout(rows []string) textareaLayout {
+	base := m.cfg.BlurredStyle.Base
+	if m.cfg.Focused() {
+		base = m.cfg.FocusedStyle.Base
+	}
+	return textareaLayout{
+		first:       base.GetBorderTopSize() + base.GetPaddingTop(),
+		end:         max(0, len(rows)-base.GetBorderBottomSize()-base.GetPaddingBottom()),
+		column:      base.GetBorderLeftSize() + base.GetPaddingLeft(),
+		numberWidth: len(strconv.Itoa(m.cfg.MaxHeight)) + 2,
+	}
+}
+
+// The line number a row of the textarea starts with, 0 for the rows without one
+func (l textareaLayout) lineNumber(row string) int {
+	start := l.column + gutterWidth
+	number, err := strconv.Atoi(strings.TrimSpace(ansi.Strip(ansi.Cut(row, start, start+l.numberWidth))))
+	if err != nil {
+		return 0
+	}
+	return number
+}
+
+// Puts a marker in the gutter (the prompt of the textarea) of the config lines that have problems
+func (m model) markErrorLines(view string) string {
+	errorLines := map[int]bool{}
+	for _, d := range m.diagnostics {
+		errorLines[d.line] = true
+	}
+
+	rows := strings.Split(view, "\n")
+	layout := m.textareaLayout(rows)
+	for i := layout.first; i < layout.end; i++ {
+		if line := layout.lineNumber(rows[i]); line > 0 && errorLines[line] {
+			rows[i] = ansi.Truncate(rows[i], layout.column, "") + errorStyle.Render("● ") + ansi.TruncateLeft(rows[i], layout.column+gutterWidth, "")
+		}
+	}
+	return strings.Join(rows, "\n")
+}
+
+// With watch, the config is reloaded whenever its file changes
+func RunLivePreview(previewConfig string, watch bool) {
+	m := newModel(previewConfig)
+	if watch {
+		if err := m.watchConfig(); err != nil {
+			fmt.Fprintln(os.Stderr, "Could not watch the config file:", err)
+			os.Exit(1)
+		}
+		defer m.watcher.Close()
+	}
+
+	if _, err := tea.NewProgram(m, tea.WithAltScreen()).Run(); err != nil {
 		// When using alternate screen, print to stderr to ensure visibility.
 		fmt.Fprintln(os.Stderr, "Error while running program:", err)
 		os.Exit(1)
@@ -327,17 +955,75 @@ func (m *model) buildTree() {
 	// Initialize treeItemRoot
 	m.treeItemRoot = m.treeItems[0]
 
+	plan := m.plan
+
 	// Only recurse if root is expanded
 	if rootExpanded {
 
-		// First, we build the tree using destination paths
-		for _, destPath := range ApplyConfigPreview([]byte(m.cfg.Value())) {
-			m.buildTreeRecursive(destPath)
+		// First, we build the tree using destination paths (or the source paths in the source view)
+		if m.sourceView {
+			sources, destinations := destinationsBySource(plan)
+			for _, src := range sources {
+				m.buildTreeRecursive(src, treeItem{destinations: destinations[src], files: 1, bytes: m.sizes[src], change: m.diff.sources[src]})
+			}
+
+			// Files that are not organized anymore stay in the tree, marked as removed
+			for _, src := range m.diff.removed {
+				m.buildTreeRecursive(src, treeItem{destinations: m.diff.oldDestinations[src], change: changeRemoved})
+			}
+		} else {
+			for _, op := range plan.Operations {
+				m.buildTreeRecursive(op.Dst, treeItem{action: op.Action, source: op.Src, files: 1, bytes: m.sizes[op.Src], change: m.diff.destinationChange(op.Src, op.Dst)})
+			}
+			for _, src := range m.diff.removed {
+				for _, dst := range m.diff.oldDestinations[src] {
+					m.buildTreeRecursive(dst, treeItem{source: src, change: changeRemoved})
+				}
+			}
+
+			// The folders that get no files are shown too, so the rules that do nothing stand out
+			for _, folder := range m.folders {
+				m.buildTreeRecursive(folder, treeItem{isDir: true})
+			}
 		}
 
 		// Then we populate tree items accordingly
 		m.populateTreeUIRecursive(m.treeItemRoot)
 	}
+	m.treeItems[0].files, m.treeItems[0].bytes = m.treeItemRoot.files, m.treeItemRoot.bytes
+
+	// The files the config does not claim get their own section, pinned above the tree
+	unmatched := plan.Unmatched
+	if m.filter {
+		unmatched = slices.DeleteFunc(slices.Clone(unmatched), func(file string) bool {
+			return !m.matchesSearch(treeItem{path: filepath.Join(m.rootPath, file)})
+		})
+	}
+	if len(unmatched) > 0 {
+		sectionExpanded := m.expandedDirs[unmatchedSectionPath]
+		section := []treeItem{{
+			path:     unmatchedSectionPath,
+			name:     "unmatched",
+			isDir:    true,
+			expanded: sectionExpanded,
+			unmatch:  true,
+		}}
+		for _, file := range unmatched {
+			section[0].files++
+			section[0].bytes += m.sizes[file]
+			if sectionExpanded {
+				section = append(section, treeItem{
+					path:    filepath.Join(m.rootPath, file),
+					name:    file,
+					depth:   1,
+					unmatch: true,
+					files:   1,
+					bytes:   m.sizes[file],
+				})
+			}
+		}
+		m.treeItems = append(section, m.treeItems...)
+	}
 
 	// Ensure cursor is in bounds
 	if m.cursor >= len(m.treeItems) {
@@ -349,8 +1035,10 @@ func (m *model) buildTree() {
 }
 
 // Given a string path we decompose it into its constituents using the path separator and then
-// add a tree item in to our directory
-func (m *model) buildTreeRecursive(path string) {
+// add a tree item in to our directory. The action, source and destinations of leaf are attached
+// to the file at the end of the path, and the directories on the way count it. A leaf that is a
+// directory (a folder of the config) is added without counting anything.
+func (m *model) buildTreeRecursive(path string, leaf treeItem) {
 
 	// Convert path separators to forward slashes for consistent splitting
 	path = filepath.ToSlash(path)
@@ -361,6 +1049,8 @@ func (m *model) buildTreeRecursive(path string) {
 		if childPath == "" {
 			continue
 		}
+		parentItem.files += leaf.files
+		parentItem.bytes += leaf.bytes
 
 		childFullPath := filepath.Join(parentItem.path, childPath)
 
@@ -386,7 +1076,7 @@ func (m *model) buildTreeRecursive(path string) {
 				isDir = info.IsDir()
 			} else {
 				// Virtual path (doesn't exist yet) - assume directories for all but last segment
-				isDir = !isLastSegment
+				isDir = !isLastSegment || leaf.isDir
 			}
 
 			childItem := treeItem{
@@ -397,6 +1087,14 @@ func (m *model) buildTreeRecursive(path string) {
 				children: []treeItem{},
 				depth:    depth + 1,
 			}
+			if isLastSegment && !leaf.isDir {
+				childItem.action = leaf.action
+				childItem.source = leaf.source
+				childItem.destinations = leaf.destinations
+				childItem.files = leaf.files
+				childItem.bytes = leaf.bytes
+				childItem.change = leaf.change
+			}
 
 			parentItem.children = append(parentItem.children, childItem)
 			parentItem = &parentItem.children[len(parentItem.children)-1]
@@ -408,6 +1106,9 @@ func (m *model) buildTreeRecursive(path string) {
 // We populate the flat tree directory using the generated tree. This is called after tree is built
 func (m *model) populateTreeUIRecursive(item treeItem) {
 	for _, child := range item.children {
+		if m.filter && !m.matchesFilter(child) {
+			continue
+		}
 
 		// Check if child path already exists in treeItems
 		found := false
@@ -451,7 +1152,17 @@ func (m model) renderTree(width, height int) string {
 		}
 	}
 
+	// The unmatched section stays at the top while the rest of the tree scrolls
+	rows := []int{}
+	if visibleStart > 0 && m.treeItems[0].path == unmatchedSectionPath {
+		rows = append(rows, 0)
+		visibleStart++
+	}
 	for i := visibleStart; i < visibleEnd; i++ {
+		rows = append(rows, i)
+	}
+
+	for _, i := range rows {
 		item := m.treeItems[i]
 		indent := strings.Repeat("  ", item.depth)
 
@@ -462,28 +1173,57 @@ func (m model) renderTree(width, height int) string {
 			} else {
 				icon = "▸ "
 			}
+		} else if symbol, ok := changeSymbols[item.change]; ok {
+			icon = symbol
 		} else {
 			icon = "  "
 		}
 
 		line := indent + icon + item.name
-		if item.isDir {
+		if item.isDir && item.path != unmatchedSectionPath {
 			line += "/"
 		}
 
-		// Truncate if too long (before styling)
-		if len(line) > width {
-			line = line[:width-3] + "..."
+		// The source view annotates the files with where they go instead of the action, and
+		// directories get the number and size of the files in them
+		marker, markerStyle := actionMarkers[item.action], actionStyles[item.action]
+		empty := item.isDir && item.files == 0 && item.depth > 0 && !item.unmatch
+		switch {
+		case m.sourceView && len(item.destinations) > 0:
+			marker, markerStyle = " → "+strings.Join(item.destinations, ", "), statusStyle
+		case empty:
+			marker, markerStyle = " no files", emptyStyle
+		case item.isDir:
+			marker, markerStyle = fmt.Sprintf(" %s, %s", plural(item.files, "file"), formatBytes(item.bytes)), statusStyle
+		}
+
+		// Truncate if too long (before styling), leaving room for the marker
+		if room := width - min(lipgloss.Width(marker), width/2); len(line) > room {
+			line = line[:max(room-3, 0)] + "..."
 		}
+		marker = ansi.Truncate(marker, width-lipgloss.Width(line), "…")
 
 		// Highlight cursor only when right pane is focused
 		if i == m.cursor && m.focusedPane == 1 {
+			line += marker
 			style := lipgloss.NewStyle().
 				Background(lipgloss.Color("62")).
 				Foreground(lipgloss.Color("230")).
 				Bold(true).
 				Width(width)
 			line = style.Render(line)
+		} else if m.stale {
+			line = staleStyle.Render(line + marker)
+		} else if m.matchesSearch(item) {
+			line = searchMatchStyle.Render(line) + markerStyle.Render(marker)
+		} else if item.change != changeNone {
+			line = changeStyles[item.change].Render(line) + markerStyle.Render(marker)
+		} else if item.unmatch {
+			line = unmatchedStyle.Render(line) + markerStyle.Render(marker)
+		} else if empty {
+			line = emptyStyle.Render(line + marker)
+		} else if marker != "" {
+			line += markerStyle.Render(marker)
 		}
 
 		b.WriteString(line + "\n")
@@ -491,3 +1231,47 @@ func (m model) renderTree(width, height int) string {
 
 	return b.String()
 }
+
+// One line about the selected item of the tree, where it comes from or where it goes to
+func (m model) selectionDetails(width int) string {
+	if m.cursor >= len(m.treeItems) {
+		return ""
+	}
+	item := m.treeItems[m.cursor]
+
+	var details string
+	switch {
+	case item.path == unmatchedSectionPath:
+		details = "files that do not match any folder"
+	case item.unmatch:
+		details = item.name + " does not match any folder"
+	case item.source != "":
+		details = "from " + item.source
+		if item.action != "" && item.action != actionCopy {
+			details += " (" + item.action + ")"
+		}
+	case len(item.destinations) > 0:
+		details = "to " + strings.Join(item.destinations, ", ")
+	default:
+		if rel, err := filepath.Rel(m.rootPath, item.path); err == nil {
+			details = filepath.ToSlash(rel)
+		}
+	}
+
+	// The source is the key of the changes, it is the item itself in the source view
+	src := item.source
+	if m.sourceView {
+		if rel, err := filepath.Rel(m.rootPath, item.path); err == nil {
+			src = filepath.ToSlash(rel)
+		}
+	}
+	switch item.change {
+	case changeAdded:
+		details += ", new since the last evaluation"
+	case changeRemoved:
+		details += ", not organized anymore"
+	case changeMoved:
+		details += ", before: " + strings.Join(m.diff.oldDestinations[src], ", ")
+	}
+	return statusStyle.Render(ansi.Truncate(details, width, "…"))
+}
@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Dialogs of the live preview, they take over the keys while open
+const (
+	dialogNone = iota
+	dialogQuit
+	dialogApply
+	dialogApplying
+	dialogApplied
+	dialogConflict
+)
+
+var (
+	dialogStyle = lipgloss.NewStyle().
+			Border(lipgloss.RoundedBorder()).
+			BorderForeground(lipgloss.Color("62")).
+			Padding(1, 2)
+
+	modifiedStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("214"))
+)
+
+// An apply started from the live preview. The reports and warnings of the run are collected
+// here instead of being printed over the screen.
+type applyRun struct {
+	tracker atomic.Pointer[progressTracker]
+
+	mu     sync.Mutex
+	output bytes.Buffer
+
+	err  error
+	took time.Duration
+}
+
+func (r *applyRun) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.output.Write(p)
+}
+
+// What applying the config of the preview would do, once the state and dedupe had their say
+type applyPlanMsg struct {
+	planID  int
+	plan    Plan
+	skipped int // already organized by an earlier run
+	err     error
+}
+
+type applyTickMsg struct{}
+
+type applyDoneMsg struct {
+	err  error
+	took time.Duration
+}
+
+func applyTick() tea.Cmd {
+	return tea.Tick(progressTickInterval, func(time.Time) tea.Msg { return applyTickMsg{} })
+}
+
+func (m model) modified() bool {
+	return m.cfg.Value() != m.savedValue
+}
+
+func (m *model) quit() tea.Cmd {
+	if m.cancelEval != nil {
+		m.cancelEval()
+	}
+	return tea.Quit
+}
+
+// Writes the config back to its file. When someone else changed the file since it was loaded,
+// the conflict dialog asks first instead and nothing is written.
+func (m *model) save() bool {
+	if disk, err := os.ReadFile(m.cfgFilePath); err == nil && string(disk) != m.diskValue {
+		m.diskChange = string(disk)
+		m.dialog = dialogConflict
+		return false
+	}
+	return m.write()
+}
+
+func (m *model) write() bool {
+	value := m.cfg.Value()
+	if err := os.WriteFile(m.cfgFilePath, []byte(value), 0644); err != nil {
+		m.notice, m.noticeErr = fmt.Sprintf("could not save: %v", err), true
+		return false
+	}
+	m.savedValue, m.diskValue = value, value
+	m.notice, m.noticeErr = "saved", false
+	return true
+}
+
+// Opens the apply dialog, as long as the preview shows what the config in the textarea does.
+// What would be applied is worked out in the background, the state can leave out a lot.
+func (m *model) confirmApply() tea.Cmd {
+	switch {
+	case m.stale:
+		m.notice, m.noticeErr = "fix the errors in the config first", true
+	case m.evaluating || m.planValue != m.cfg.Value():
+		m.notice, m.noticeErr = "the preview is not up to date yet, try again in a moment", true
+	case len(m.plan.Operations) == 0:
+		m.notice, m.noticeErr = "nothing to apply", true
+	default:
+		m.dialog = dialogApply
+		m.applyPlan = nil
+		planID, data := m.planID, m.data
+		return func() tea.Msg {
+			state, err := openDefaultState()
+			if err != nil {
+				return applyPlanMsg{planID: planID, err: fmt.Errorf("failed to load state: %w", err)}
+			}
+			plan, skipped, err := previewPlan(data, applyOptions{state: state})
+			return applyPlanMsg{planID: planID, plan: plan, skipped: skipped, err: err}
+		}
+	}
+	return nil
+}
+
+// Applies the config of the textarea in the background, the same way -config-apply does
+func (m *model) startApply() tea.Cmd {
+	run := &applyRun{}
+	m.applyRun = run
+	m.dialog = dialogApplying
+
+	yamlFile, configFile := []byte(m.cfg.Value()), m.cfgFilePath
+	apply := func() tea.Msg {
+		start := time.Now()
+		err := applyInPreview(yamlFile, configFile, run)
+		return applyDoneMsg{err: err, took: time.Since(start)}
+	}
+	return tea.Batch(apply, applyTick())
+}
+
+func applyInPreview(yamlFile []byte, configFile string, run *applyRun) error {
+	data, err := parseConfig(yamlFile)
+	if err != nil {
+		return err
+	}
+	data.configFile = candidatePath(configFile)
+	state, err := openDefaultState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	plan, err := planConfig(data, nil)
+	if err != nil {
+		return err
+	}
+
+	opts := applyOptions{
+		state:      state,
+		out:        run,
+		onProgress: func(t *progressTracker) { run.tracker.Store(t) },
+	}
+	err = applyPlan(data, plan.Operations, opts)
+	printUnmatchedReport(run, data, plan.Unmatched)
+	return err
+}
+
+func (m model) updateDialog(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch m.dialog {
+	case dialogQuit:
+		switch msg.String() {
+		case "y":
+			m.dialog = dialogNone
+			if !m.save() {
+				m.quitSaved = m.dialog == dialogConflict
+				return m, nil
+			}
+			return m, m.quit()
+		case "n":
+			return m, m.quit()
+		case "esc":
+			m.dialog = dialogNone
+		}
+
+	case dialogApply:
+		switch msg.String() {
+		case "y", "enter":
+			// Only once it is known what would be applied
+			if m.applyPlan != nil && m.applyPlan.err == nil && len(m.applyPlan.plan.Operations) > 0 {
+				return m, m.startApply()
+			}
+		case "n", "esc":
+			m.dialog = dialogNone
+		}
+
+	case dialogConflict:
+		quit := m.quitSaved
+		switch msg.String() {
+		case "o":
+			m.dialog, m.quitSaved = dialogNone, false
+			if m.write() && quit {
+				return m, m.quit()
+			}
+		case "l":
+			m.dialog, m.quitSaved = dialogNone, false
+			return m, m.load(m.diskChange, "loaded "+filepath.Base(m.cfgFilePath)+" from disk")
+		case "esc":
+			m.dialog, m.quitSaved = dialogNone, false
+		}
+
+	case dialogApplied:
+		switch msg.String() {
+		case "enter", "esc", "q":
+			m.dialog = dialogNone
+		}
+	}
+
+	// Nothing can be done while applying, it has to finish first
+	return m, nil
+}
+
+// Name of the config file, whether it has unsaved changes and the last notice
+func (m model) configHeader(width int) string {
+	header := filepath.Base(m.cfgFilePath)
+	if m.modified() {
+		header += modifiedStyle.Render(" ● modified")
+	}
+	if m.notice != "" {
+		style := statusStyle
+		if m.noticeErr {
+			style = errorStyle
+		}
+		header += "  " + style.Render(m.notice)
+	}
+	return lipgloss.NewStyle().MaxWidth(width).Render(header)
+}
+
+func (m model) dialogView() string {
+	var b strings.Builder
+
+	switch m.dialog {
+	case dialogQuit:
+		fmt.Fprintf(&b, "%s has unsaved changes, save them before quitting?\n\n", filepath.Base(m.cfgFilePath))
+		b.WriteString(statusStyle.Render("[y] save and quit   [n] quit without saving   [esc] cancel"))
+
+	case dialogConflict:
+		fmt.Fprintf(&b, "%s changed on disk since it was loaded, saving would overwrite that change.\n\n", filepath.Base(m.cfgFilePath))
+		b.WriteString(statusStyle.Render("[o] overwrite it with yours   [l] load it and drop yours   [esc] cancel"))
+
+	case dialogApply:
+		fmt.Fprintf(&b, "Apply the config to %s?\n\n", m.rootPath)
+
+		m.applyDialog(&b)
+
+	case dialogApplying:
+		b.WriteString("Applying…\n\n")
+		if tracker := m.applyRun.tracker.Load(); tracker != nil {
+			b.WriteString(m.applyBar.ViewAs(tracker.fraction()) + "\n\n" + tracker.String())
+		} else {
+			b.WriteString(m.applyBar.ViewAs(0))
+		}
+
+	case dialogApplied:
+		run := m.applyRun
+		if run.err != nil {
+			fmt.Fprintf(&b, "%s\n", errorStyle.Render(fmt.Sprintf("Finished with errors after %s", run.took.Round(time.Millisecond))))
+			b.WriteString(limitLines(run.err.Error(), 8) + "\n")
+		} else {
+			fmt.Fprintf(&b, "Done in %s\n", run.took.Round(time.Millisecond))
+		}
+		if tracker := run.tracker.Load(); tracker != nil {
+			fmt.Fprintf(&b, "Organized %s (%s)\n", plural(int(tracker.doneFiles.Load()), "file"), formatBytes(tracker.doneBytes.Load()))
+		}
+
+		run.mu.Lock()
+		report := strings.TrimSpace(run.output.String())
+		run.mu.Unlock()
+		if report != "" {
+			b.WriteString("\n" + limitLines(report, 12) + "\n")
+		}
+		b.WriteString("\n" + statusStyle.Render("[enter] close"))
+	}
+
+	// Long lines (eg: errors) get wrapped to fit the screen
+	content := b.String()
+	width := min(lipgloss.Width(content), max(m.width-10, 20))
+	return dialogStyle.Width(width + dialogStyle.GetHorizontalPadding()).Render(content)
+}
+
+// What the apply dialog says the apply would do, the same counts the apply itself would end up with
+func (m model) applyDialog(b *strings.Builder) {
+	preview := m.applyPlan
+	if preview == nil {
+		b.WriteString("Working out what would be applied…\n\n")
+		b.WriteString(statusStyle.Render("[n] cancel"))
+		return
+	}
+	if preview.err != nil {
+		b.WriteString(errorStyle.Render(preview.err.Error()) + "\n\n")
+		b.WriteString(statusStyle.Render("[n] cancel"))
+		return
+	}
+
+	counts := map[string]int{}
+	for _, op := range preview.plan.Operations {
+		counts[op.Action]++
+	}
+	for _, action := range []string{actionCopy, actionMove, actionSymlink, actionHardlink, actionReflink} {
+		if counts[action] > 0 {
+			fmt.Fprintf(b, "  %-9s %s\n", action, plural(counts[action], "file"))
+		}
+	}
+	if len(preview.plan.Unmatched) > 0 {
+		fmt.Fprintf(b, "  %-9s %s\n", "unmatched", plural(len(preview.plan.Unmatched), "file"))
+	}
+	if preview.skipped > 0 {
+		fmt.Fprintf(b, "  %-9s %s (already organized by an earlier run)\n", "skipped", plural(preview.skipped, "file"))
+	}
+	if len(preview.plan.Operations) == 0 {
+		b.WriteString("\nNothing left to apply.\n\n")
+		b.WriteString(statusStyle.Render("[n] close"))
+		return
+	}
+	b.WriteString("\n" + statusStyle.Render("[y] apply   [n] cancel"))
+}
+
+// Keeps the first lines of a text, saying how many were left out
+func limitLines(text string, limit int) string {
+	lines := strings.Split(text, "\n")
+	if len(lines) <= limit {
+		return text
+	}
+	return strings.Join(lines[:limit], "\n") + fmt.Sprintf("\n… and %d more lines", len(lines)-limit)
+}
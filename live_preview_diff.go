@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/lipgloss"
+)
+
+// How a file changed since the previous evaluation
+const (
+	changeNone = iota
+	changeAdded
+	changeRemoved
+	changeMoved
+)
+
+var (
+	changeStyles = map[int]lipgloss.Style{
+		changeAdded:   lipgloss.NewStyle().Foreground(lipgloss.Color("78")),
+		changeRemoved: lipgloss.NewStyle().Foreground(lipgloss.Color("203")),
+		changeMoved:   lipgloss.NewStyle().Foreground(lipgloss.Color("221")),
+	}
+	changeSymbols = map[int]string{
+		changeAdded:   "+ ",
+		changeRemoved: "− ",
+		changeMoved:   "~ ",
+	}
+)
+
+// What changed in the plan between two evaluations, by source file. A source is added when it
+// was not organized before, removed when it is not anymore and moved when it goes somewhere else.
+type planDiff struct {
+	sources         map[string]int
+	oldDestinations map[string][]string // where the changed sources went before
+	removed         []string            // the removed sources, in the order of the old plan
+}
+
+func destinationsBySource(plan Plan) ([]string, map[string][]string) {
+	sources := []string{}
+	destinations := map[string][]string{}
+	for _, op := range plan.Operations {
+		if _, ok := destinations[op.Src]; !ok {
+			sources = append(sources, op.Src)
+		}
+		destinations[op.Src] = append(destinations[op.Src], op.Dst)
+	}
+	return sources, destinations
+}
+
+func diffPlans(before, after Plan) planDiff {
+	diff := planDiff{sources: map[string]int{}, oldDestinations: map[string][]string{}}
+	oldSources, oldDestinations := destinationsBySource(before)
+	newSources, newDestinations := destinationsBySource(after)
+
+	for _, src := range newSources {
+		old, ok := oldDestinations[src]
+		switch {
+		case !ok:
+			diff.sources[src] = changeAdded
+		case !slices.Equal(slices.Sorted(slices.Values(old)), slices.Sorted(slices.Values(newDestinations[src]))):
+			diff.sources[src] = changeMoved
+			diff.oldDestinations[src] = old
+		}
+	}
+	for _, src := range oldSources {
+		if _, ok := newDestinations[src]; !ok {
+			diff.sources[src] = changeRemoved
+			diff.oldDestinations[src] = oldDestinations[src]
+			diff.removed = append(diff.removed, src)
+		}
+	}
+	return diff
+}
+
+// The change of a destination of src in the new plan. Only the new destinations of a moved
+// source count as moved.
+func (d planDiff) destinationChange(src, dst string) int {
+	change := d.sources[src]
+	if change == changeMoved && slices.Contains(d.oldDestinations[src], dst) {
+		return changeNone
+	}
+	return change
+}
+
+// Like "+12 −3 ~5", empty when nothing changed
+func (d planDiff) summary() string {
+	counts := map[int]int{}
+	for _, change := range d.sources {
+		counts[change]++
+	}
+
+	parts := []string{}
+	for _, change := range []int{changeAdded, changeRemoved, changeMoved} {
+		if counts[change] > 0 {
+			symbol := strings.TrimSpace(changeSymbols[change])
+			parts = append(parts, changeStyles[change].Render(fmt.Sprintf("%s%d", symbol, counts[change])))
+		}
+	}
+	return strings.Join(parts, " ")
+}
+
+// Moves the cursor to the next (step 1) or previous (step -1) changed file
+func (m *model) jumpToChange(step int) {
+	if !m.jumpTo(step, false, func(item treeItem) bool { return item.change != changeNone }) {
+		m.notice, m.noticeErr = "nothing changed since the last evaluation", false
+	}
+}
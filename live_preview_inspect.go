@@ -0,0 +1,215 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// How much of a file is read to detect its type and show its beginning
+const peekSize = 512
+
+// The file the selected item of the tree is about, relative to the current directory
+func (m model) selectedFile() (string, bool) {
+	if m.cursor >= len(m.treeItems) {
+		return "", false
+	}
+	item := m.treeItems[m.cursor]
+	switch {
+	case item.isDir:
+		return "", false
+	case item.source != "":
+		return item.source, true
+	}
+	// Files of the source view and of the unmatched section are where they are
+	return m.relPath(item), true
+}
+
+// The inspection of a file, for the selection (and plan) of key
+type inspectResultMsg struct {
+	key   string
+	lines []string
+}
+
+// Works out what the inspector shows for the selected file, only when the selection (or the
+// plan) changed since the last time. Reading the file can be slow (eg: on a network drive), so
+// it happens in the background and the result is dropped when the selection moved on.
+func (m *model) inspect() tea.Cmd {
+	if !m.inspecting {
+		return nil
+	}
+	file, ok := m.selectedFile()
+	key := fmt.Sprintf("%s\x00%d", file, m.planID)
+	if key == m.inspected {
+		return nil
+	}
+	m.inspected = key
+	m.inspection = nil
+	if !ok {
+		return nil
+	}
+	m.inspection = []string{"source    " + file, statusStyle.Render("inspecting…")}
+	data := m.data
+	return func() tea.Msg {
+		return inspectResultMsg{key: key, lines: inspectFile(data, file)}
+	}
+}
+
+// The lines of the inspector for a file: its details, the folders of the config that looked at
+// it (the same trace as fileo explain) and the beginning of its contents
+func inspectFile(data ConfigData, file string) []string {
+	lines := []string{"source    " + file}
+
+	info, err := os.Stat(file)
+	if err != nil {
+		return append(lines, errorStyle.Render(err.Error()))
+	}
+	lines = append(lines,
+		fmt.Sprintf("size      %s", formatBytes(info.Size())),
+		fmt.Sprintf("modified  %s", info.ModTime().Format("2006-01-02 15:04:05")),
+	)
+
+	head, err := readHead(file)
+	if err != nil {
+		return append(lines, errorStyle.Render(err.Error()))
+	}
+	mime := http.DetectContentType(head)
+	lines = append(lines, "type      "+mime, "")
+
+	e, err := explainFile(data, file)
+	if err != nil {
+		lines = append(lines, errorStyle.Render(err.Error()))
+	} else {
+		lines = append(lines, statusStyle.Render("rules"))
+		lines = append(lines, traceLines(e.Folders, 1)...)
+		if len(e.Operations) == 0 {
+			lines = append(lines, "  no folder takes it")
+		}
+	}
+
+	lines = append(lines, "", statusStyle.Render("contents"))
+	return append(lines, peekLines(head, mime)...)
+}
+
+func readHead(file string) ([]byte, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	head := make([]byte, peekSize)
+	n, err := io.ReadFull(f, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
+// A short version of the trace of fileo explain: one line per folder that looked at the file,
+// with the extensions and patterns that matched it
+func traceLines(traces []folderTrace, depth int) []string {
+	lines := []string{}
+	indent := strings.Repeat("  ", depth)
+	for _, trace := range traces {
+		if !trace.InParent {
+			continue
+		}
+
+		mark, details := "✗", ""
+		matched := []string{}
+		for _, m := range trace.Matchers {
+			if m.Matched {
+				matched = append(matched, m.Kind+" "+m.Value)
+			}
+		}
+		switch {
+		case !trace.InScope:
+			details = "does not recurse"
+		case trace.Matched:
+			mark, details = "✓", strings.Join(matched, ", ")
+		}
+		switch {
+		case trace.DroppedBy != "":
+			details += ", dropped by policy " + trace.DroppedBy
+		case trace.Destination != "":
+			details += " → " + trace.Destination
+		}
+
+		lines = append(lines, strings.TrimRight(fmt.Sprintf("%s%s %s/  %s", indent, mark, path.Base(trace.Folder), details), " "))
+		lines = append(lines, traceLines(trace.Children, depth+1)...)
+	}
+	return lines
+}
+
+// The first lines of a text file, or a hex dump of the beginning of anything else
+func peekLines(head []byte, mime string) []string {
+	if len(head) == 0 {
+		return []string{statusStyle.Render("(empty)")}
+	}
+
+	text := head
+	if len(head) == peekSize {
+		text = trimPartialRune(head)
+	}
+	if strings.HasPrefix(mime, "text/") && utf8.Valid(text) {
+		lines := strings.Split(strings.TrimRight(string(text), "\n"), "\n")
+		for i, line := range lines {
+			// Tabs and control characters would break the layout
+			lines[i] = strings.Map(func(r rune) rune {
+				switch {
+				case r == '\t':
+					return ' '
+				case unicode.IsControl(r):
+					return '·'
+				}
+				return r
+			}, line)
+		}
+		return lines
+	}
+	return strings.Split(strings.TrimRight(hex.Dump(head[:min(len(head), 64)]), "\n"), "\n")
+}
+
+// Leaves out the rune a head cut at peekSize can end with half of, which would make the text
+// look invalid
+func trimPartialRune(head []byte) []byte {
+	// The last rune starts at most utf8.UTFMax-1 bytes before the end
+	for i := 1; i < utf8.UTFMax && i <= len(head); i++ {
+		if start := len(head) - i; utf8.RuneStart(head[start]) {
+			if !utf8.FullRune(head[start:]) {
+				return head[:start]
+			}
+			break
+		}
+	}
+	return head
+}
+
+// The inspector, below the tree
+func (m model) inspectorView(width, height int) string {
+	lines := []string{statusStyle.Render(ansi.Truncate("── inspector "+strings.Repeat("─", width), width, ""))}
+
+	if m.inspection == nil {
+		lines = append(lines, statusStyle.Render("select a file to see its details"))
+	}
+	for _, line := range m.inspection {
+		if len(lines) == height {
+			break
+		}
+		lines = append(lines, ansi.Truncate(line, width, "…"))
+	}
+	for len(lines) < height {
+		lines = append(lines, "")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	tea "github.com/charmbracelet/bubbletea"
+)
+
+// Sent when the config file changed on disk
+type configChangedMsg struct{}
+
+type configWatchErrMsg struct {
+	err error
+}
+
+// Sent once the editor opened on the config exits
+type editorDoneMsg struct {
+	err error
+}
+
+// Starts watching the config file, so changes made to it in another editor show up in the preview
+func (m *model) watchConfig() error {
+	configPath, err := filepath.Abs(m.cfgFilePath)
+	if err != nil {
+		return err
+	}
+	m.watcher, err = newFileWatcher(filepath.Dir(configPath), false)
+	return err
+}
+
+// Waits for the next change of the config file
+func (m model) waitForConfigChange() tea.Cmd {
+	if m.watcher == nil {
+		return nil
+	}
+	watcher := m.watcher
+	configPath, _ := filepath.Abs(m.cfgFilePath)
+	return func() tea.Msg {
+		for {
+			select {
+			case event := <-watcher.Events():
+				// The other files of the directory are watched too. Only a complete save counts,
+				// the file is empty for a moment while an editor writes it.
+				if event.path == configPath && event.written {
+					return configChangedMsg{}
+				}
+			case err := <-watcher.Errors():
+				return configWatchErrMsg{err: err}
+			}
+		}
+	}
+}
+
+// Loads the config file again after it changed on disk. Unsaved changes in the textarea are not
+// thrown away for it, they are kept instead (and saving asks before overwriting the change).
+func (m *model) reloadConfig() tea.Cmd {
+	data, err := os.ReadFile(m.cfgFilePath)
+	if err != nil {
+		m.notice, m.noticeErr = fmt.Sprintf("could not reload: %v", err), true
+		return nil
+	}
+	value := string(data)
+	if value == m.diskValue {
+		// Our own save, or the editor did not change anything
+		return nil
+	}
+	if m.modified() {
+		m.notice, m.noticeErr = filepath.Base(m.cfgFilePath)+" changed on disk, keeping your unsaved changes", true
+		return nil
+	}
+	return m.load(value, "reloaded "+filepath.Base(m.cfgFilePath))
+}
+
+// Replaces the textarea with the contents of the config file
+func (m *model) load(value, notice string) tea.Cmd {
+	// Stay on the same line, as far as it still exists
+	row := m.cfg.Line()
+	m.cfg.SetValue(value)
+	for m.cfg.Line() > 0 {
+		m.cfg.CursorUp()
+	}
+	for range row {
+		m.cfg.CursorDown()
+	}
+	m.cfg.CursorStart()
+
+	m.savedValue, m.diskValue = m.cfg.Value(), value
+	m.notice, m.noticeErr = notice, false
+	return m.edited()
+}
+
+// Opens the config in $EDITOR, the preview is suspended until the editor exits. Changes made in
+// the textarea are saved first so the editor gets them.
+func (m *model) openEditor() tea.Cmd {
+	editor := strings.Fields(os.Getenv("EDITOR"))
+	if len(editor) == 0 {
+		m.notice, m.noticeErr = "set $EDITOR to edit the config in your own editor", true
+		return nil
+	}
+	if m.modified() && !m.save() {
+		return nil
+	}
+
+	cmd := exec.Command(editor[0], append(editor[1:], m.cfgFilePath)...)
+	return tea.ExecProcess(cmd, func(err error) tea.Msg { return editorDoneMsg{err: err} })
+}
@@ -0,0 +1,205 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/charmbracelet/bubbles/textinput"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+)
+
+// Items of the tree matching the search
+var searchMatchStyle = lipgloss.NewStyle().Foreground(lipgloss.Color("213")).Bold(true)
+
+func newSearchInput() textinput.Model {
+	t := textinput.New()
+	t.Prompt = "/"
+	t.Placeholder = "search"
+	return t
+}
+
+// Whether all the characters of query appear in text in the same order (ignoring case), the
+// way fuzzy finders match
+func fuzzyMatch(query, text string) bool {
+	text = strings.ToLower(text)
+	for _, r := range strings.ToLower(query) {
+		at := strings.IndexRune(text, r)
+		if at < 0 {
+			return false
+		}
+		text = text[at+len(string(r)):]
+	}
+	return true
+}
+
+// Path of an item relative to the directory of the preview, the way it is searched
+func (m model) relPath(item treeItem) string {
+	if item.path == unmatchedSectionPath {
+		return ""
+	}
+	rel, err := filepath.Rel(m.rootPath, item.path)
+	if err != nil {
+		return item.path
+	}
+	return filepath.ToSlash(rel)
+}
+
+func (m model) matchesSearch(item treeItem) bool {
+	return m.query != "" && item.path != unmatchedSectionPath && fuzzyMatch(m.query, m.relPath(item))
+}
+
+// Whether an item or anything in it matches the search, the filter keeps these
+func (m model) matchesFilter(item treeItem) bool {
+	if m.matchesSearch(item) {
+		return true
+	}
+	return slices.ContainsFunc(item.children, m.matchesFilter)
+}
+
+// Every item of the tree in the order of the fully expanded tree, the unmatched files first
+func (m model) allTreeItems() []treeItem {
+	order := []treeItem{}
+	for _, file := range m.plan.Unmatched {
+		order = append(order, treeItem{path: filepath.Join(m.rootPath, file), name: file, unmatch: true})
+	}
+
+	var walk func(item treeItem)
+	walk = func(item treeItem) {
+		for _, child := range item.children {
+			order = append(order, child)
+			walk(child)
+		}
+	}
+	walk(m.treeItemRoot)
+	return order
+}
+
+// Expands the directories (or the unmatched section) an item is in
+func (m *model) reveal(item treeItem) {
+	if item.unmatch {
+		m.expandedDirs[unmatchedSectionPath] = true
+		return
+	}
+	for dir := filepath.Dir(item.path); strings.HasPrefix(dir, m.rootPath); dir = filepath.Dir(dir) {
+		m.expandedDirs[dir] = true
+		if dir == m.rootPath {
+			break
+		}
+	}
+}
+
+// Moves the cursor to the next (step 1) or previous (step -1) item accepted by match, in the
+// order of the fully expanded tree, starting at the cursor or at the top of the tree. The
+// directories the item is in are expanded. Returns false when nothing matches.
+func (m *model) jumpTo(step int, fromTop bool, match func(treeItem) bool) bool {
+	order := m.allTreeItems()
+	if len(order) == 0 {
+		return false
+	}
+
+	current := -1
+	if !fromTop && m.cursor < len(m.treeItems) {
+		selected := m.treeItems[m.cursor]
+		current = slices.IndexFunc(order, func(item treeItem) bool {
+			return item.path == selected.path && item.unmatch == selected.unmatch
+		})
+	}
+	if current < 0 && step < 0 {
+		current = 0
+	}
+
+	for k := 1; k <= len(order); k++ {
+		target := order[((current+step*k)%len(order)+len(order))%len(order)]
+		if !match(target) {
+			continue
+		}
+
+		m.reveal(target)
+		m.buildTree()
+		m.cursor = max(slices.IndexFunc(m.treeItems, func(item treeItem) bool {
+			return item.path == target.path && item.unmatch == target.unmatch
+		}), 0)
+		return true
+	}
+	return false
+}
+
+// Expands the directories of everything that matches the search, so the filter shows them all
+func (m *model) revealMatches() {
+	for _, item := range m.allTreeItems() {
+		if m.matchesSearch(item) {
+			m.reveal(item)
+		}
+	}
+}
+
+// Sets the search, jumping to the first match
+func (m *model) setQuery(query string) {
+	m.query = query
+	if m.query == "" {
+		m.filter = false
+	}
+	if m.filter {
+		m.revealMatches()
+	}
+	if !m.jumpTo(1, true, m.matchesSearch) {
+		m.buildTree()
+	}
+}
+
+// Typing the search, it is updated as it is typed
+func (m model) updateSearch(msg tea.KeyMsg) (tea.Model, tea.Cmd) {
+	switch msg.String() {
+	case "enter":
+		m.searching = false
+		m.search.Blur()
+		return m, nil
+	case "esc", "ctrl+c":
+		m.searching = false
+		m.search.Blur()
+		m.setQuery("")
+		return m, nil
+	}
+
+	var cmd tea.Cmd
+	m.search, cmd = m.search.Update(msg)
+	if m.search.Value() != m.query {
+		m.setQuery(m.search.Value())
+	}
+	return m, cmd
+}
+
+// Shows the search while it is typed, or how many items match it
+func (m model) searchStatus() string {
+	if m.query == "" {
+		return ""
+	}
+	matches := 0
+	for _, item := range m.allTreeItems() {
+		if m.matchesSearch(item) {
+			matches++
+		}
+	}
+	status := fmt.Sprintf("/%s: %d matches", m.query, matches)
+	if m.filter {
+		status += ", filtered"
+	}
+	return statusStyle.Render(status)
+}
+
+// Expands (or collapses) every directory of the tree. The root stays expanded.
+func (m *model) setAllExpanded(expanded bool) {
+	for _, item := range m.allTreeItems() {
+		if item.isDir {
+			m.expandedDirs[item.path] = expanded
+		}
+	}
+	if len(m.plan.Unmatched) > 0 {
+		m.expandedDirs[unmatchedSectionPath] = expanded
+	}
+	m.expandedDirs[m.rootPath] = true
+	m.buildTree()
+}
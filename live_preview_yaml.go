@@ -0,0 +1,322 @@
+package main
+
+import (
+	"path/filepath"
+	"slices"
+	"strings"
+	"unicode"
+
+	"github.com/charmbracelet/lipgloss"
+	"github.com/charmbracelet/x/ansi"
+)
+
+// What a character of the config is, for highlighting
+const (
+	tokenPlain = iota
+	tokenKey
+	tokenString
+	tokenComment
+	tokenRegex
+)
+
+var tokenStyles = map[int]lipgloss.Style{
+	tokenKey:     lipgloss.NewStyle().Foreground(lipgloss.Color("75")),
+	tokenString:  lipgloss.NewStyle().Foreground(lipgloss.Color("114")),
+	tokenComment: lipgloss.NewStyle().Foreground(lipgloss.Color("243")),
+	tokenRegex:   lipgloss.NewStyle().Foreground(lipgloss.Color("215")),
+}
+
+// The keys a config knows about, offered by the completion
+var (
+	topLevelKeys = []string{"preserve", "policy", "unmatched", "dedupe", "sync", "delete_permanently", "folders"}
+	folderKeys   = []string{"name", "extensions", "patterns", "recurse", "folders", "action", "symlink_target", "link_fallback", "policy", "priority"}
+)
+
+// The parts of a line of the config we care about. This is not a yaml parser, only enough of
+// one to highlight and complete the configs fileo uses.
+type configLine struct {
+	indent     int    // spaces before the content (or the dash of a list item)
+	item       bool   // the line starts a list item
+	key        string // key of the line, empty when it has none
+	keyStart   int    // rune index where the key starts (or where the value starts without a key)
+	valueStart int    // rune index right after the colon of the key
+	value      string // rest of the line after the key, without a comment
+}
+
+func parseConfigLine(line string) configLine {
+	runes := []rune(line)
+	i := 0
+	for i < len(runes) && runes[i] == ' ' {
+		i++
+	}
+	parsed := configLine{indent: i}
+	if i+1 < len(runes) && runes[i] == '-' && runes[i+1] == ' ' || i+1 == len(runes) && runes[i] == '-' {
+		parsed.item = true
+		i++
+		for i < len(runes) && runes[i] == ' ' {
+			i++
+		}
+	}
+	parsed.keyStart, parsed.valueStart = i, i
+
+	end := i
+	for end < len(runes) && (unicode.IsLetter(runes[end]) || unicode.IsDigit(runes[end]) || runes[end] == '_' || runes[end] == '-') {
+		end++
+	}
+	if end > i && end < len(runes) && runes[end] == ':' && (end+1 == len(runes) || runes[end+1] == ' ') {
+		parsed.key = string(runes[i:end])
+		parsed.valueStart = end + 1
+	}
+
+	value := string(runes[parsed.valueStart:])
+	if at := strings.Index(value, " #"); at >= 0 {
+		value = value[:at]
+	} else if strings.HasPrefix(strings.TrimSpace(value), "#") {
+		value = ""
+	}
+	parsed.value = strings.TrimSpace(value)
+	return parsed
+}
+
+// For every line, the key its value belongs to. That is the key of the line itself, or for list
+// items without one (eg: "- txt") the key the list was started by.
+func valueKeys(lines []string) []string {
+	keys := make([]string, len(lines))
+	blockKey := ""
+	for i, line := range lines {
+		parsed := parseConfigLine(line)
+		switch {
+		case parsed.key != "":
+			keys[i] = parsed.key
+			blockKey = ""
+			if parsed.value == "" {
+				blockKey = parsed.key
+			}
+		case parsed.item:
+			keys[i] = blockKey
+		}
+	}
+	return keys
+}
+
+// Works out the token of every character of the config, line by line
+func highlightConfig(value string) [][]int {
+	lines := strings.Split(value, "\n")
+	keys := valueKeys(lines)
+
+	tokens := make([][]int, len(lines))
+	for l, line := range lines {
+		runes := []rune(line)
+		lineTokens := make([]int, len(runes))
+		parsed := parseConfigLine(line)
+		if parsed.key != "" {
+			for i := parsed.keyStart; i < parsed.valueStart-1; i++ {
+				lineTokens[i] = tokenKey
+			}
+		}
+
+		// Everything in the value of patterns is a regex, quoted or not
+		regex := keys[l] == "patterns"
+		for i := parsed.valueStart; i < len(runes); i++ {
+			switch r := runes[i]; {
+			case r == '#' && (i == 0 || runes[i-1] == ' '):
+				for ; i < len(runes); i++ {
+					lineTokens[i] = tokenComment
+				}
+
+			case r == '\'' || r == '"':
+				token := tokenString
+				if regex {
+					token = tokenRegex
+				}
+				end := closingQuote(runes, i)
+				for j := i; j <= end; j++ {
+					lineTokens[j] = token
+				}
+				i = end
+
+			case regex && !strings.ContainsRune("[], ", r):
+				lineTokens[i] = tokenRegex
+			}
+		}
+		tokens[l] = lineTokens
+	}
+	return tokens
+}
+
+// Index of the quote closing the string that starts at open, or the end of the line
+func closingQuote(runes []rune, open int) int {
+	quote := runes[open]
+	for i := open + 1; i < len(runes); i++ {
+		switch {
+		case quote == '"' && runes[i] == '\\':
+			i++
+		case runes[i] == quote && quote == '\'' && i+1 < len(runes) && runes[i+1] == '\'':
+			i++ // '' is an escaped quote
+		case runes[i] == quote:
+			return i
+		}
+	}
+	return len(runes) - 1
+}
+
+// Colors the config in the view of the textarea. The textarea can not style its text, so the
+// rows it rendered are matched back to the lines of the config (through the line numbers, see
+// textareaLayout) and their text is drawn again with the colors. The line with the cursor is
+// left alone, it has its own highlight.
+func (m model) highlightView(view string) string {
+	lines := strings.Split(m.cfg.Value(), "\n")
+	tokens := highlightConfig(m.cfg.Value())
+
+	line, offset := -1, 0
+	rows := strings.Split(view, "\n")
+	layout := m.textareaLayout(rows)
+	for i := layout.first; i < layout.end; i++ {
+		row := rows[i]
+		plain := ansi.Strip(row)
+
+		// Rows without a number continue the line above (wrapped), until the end of the buffer
+		if n := layout.lineNumber(plain); n > 0 {
+			line, offset = n-1, 0
+		}
+		if line < 0 || line >= len(lines) || m.cfg.Focused() && line == m.cfg.Line() {
+			continue
+		}
+
+		textStart := layout.column + gutterWidth + layout.numberWidth
+		text := []rune(ansi.Cut(plain, textStart, textStart+m.cfg.Width()))
+
+		// The text of the row is the part of the line it is at, followed by padding
+		lineRunes := []rune(lines[line])
+		shown := 0
+		for shown < len(text) && offset+shown < len(lineRunes) && text[shown] == lineRunes[offset+shown] {
+			shown++
+		}
+
+		var b strings.Builder
+		for start := 0; start < shown; {
+			token := tokens[line][offset+start]
+			end := start
+			for end < shown && tokens[line][offset+end] == token {
+				end++
+			}
+			if style, ok := tokenStyles[token]; ok {
+				b.WriteString(style.Render(string(text[start:end])))
+			} else {
+				b.WriteString(string(text[start:end]))
+			}
+			start = end
+		}
+		b.WriteString(string(text[shown:]))
+		offset += shown
+
+		rows[i] = ansi.Truncate(row, textStart, "") + b.String() + ansi.TruncateLeft(row, textStart+m.cfg.Width(), "")
+	}
+	return strings.Join(rows, "\n")
+}
+
+// Indents the line the cursor was just moved to by a newline, following the line above: keys
+// of the same folder line up, and a new list item is started after "folders:" or another item.
+func (m *model) autoIndent() {
+	lines := strings.Split(m.cfg.Value(), "\n")
+	row := m.cfg.Line()
+	if row == 0 || row >= len(lines) || lines[row] != "" {
+		return
+	}
+
+	above := parseConfigLine(lines[row-1])
+	if strings.TrimSpace(lines[row-1]) == "" {
+		return
+	}
+	keyColumn := above.keyStart
+
+	var indent string
+	switch {
+	case above.key == "folders" && above.value == "":
+		indent = strings.Repeat(" ", keyColumn+2) + "- "
+	case above.key != "" && above.value == "":
+		indent = strings.Repeat(" ", keyColumn+2)
+	case above.item && above.key == "":
+		indent = strings.Repeat(" ", above.indent) + "- "
+	default:
+		indent = strings.Repeat(" ", keyColumn)
+	}
+	m.cfg.InsertString(indent)
+}
+
+// The extensions of the files in the current directory, as they are written in a config
+func (m model) treeExtensions() []string {
+	files := slices.Clone(m.plan.Unmatched)
+	for _, op := range m.plan.Operations {
+		files = append(files, op.Src)
+	}
+
+	extensions := []string{}
+	for _, file := range files {
+		if ext := strings.TrimPrefix(filepath.Ext(file), "."); ext != "" && !slices.Contains(extensions, ext) {
+			extensions = append(extensions, ext)
+		}
+	}
+	slices.Sort(extensions)
+	return extensions
+}
+
+// Completes the word before the cursor: a key at the start of a line, or an extension in the
+// value of extensions. The common part of all the matches is inserted and the matches listed.
+func (m *model) complete() {
+	m.notice = ""
+	lines := strings.Split(m.cfg.Value(), "\n")
+	row := m.cfg.Line()
+	info := m.cfg.LineInfo()
+	before := []rune(lines[row])[:info.StartColumn+info.ColumnOffset]
+
+	parsed := parseConfigLine(string(before))
+	word := string(before[parsed.keyStart:])
+
+	var options []string
+	suffix := ""
+	switch {
+	case parsed.key == "" && !strings.ContainsAny(word, " :[,'\"#"):
+		options = folderKeys
+		if parsed.indent == 0 && !parsed.item {
+			options = topLevelKeys
+		}
+		suffix = ": "
+
+	case valueKeys(lines[:row+1])[row] == "extensions":
+		start := len(before)
+		for start > 0 && !strings.ContainsRune(" [,'\"", before[start-1]) {
+			start--
+		}
+		word = string(before[start:])
+		options = m.treeExtensions()
+
+	default:
+		m.notice, m.noticeErr = "nothing to complete here", true
+		return
+	}
+
+	matches := []string{}
+	for _, option := range options {
+		if strings.HasPrefix(option, word) {
+			matches = append(matches, option)
+		}
+	}
+	if len(matches) == 0 {
+		m.notice, m.noticeErr = "no completions", true
+		return
+	}
+	if len(matches) == 1 {
+		m.cfg.InsertString(strings.TrimPrefix(matches[0], word) + suffix)
+		return
+	}
+
+	common := matches[0]
+	for _, match := range matches[1:] {
+		for !strings.HasPrefix(match, common) {
+			common = common[:len(common)-1]
+		}
+	}
+	m.cfg.InsertString(strings.TrimPrefix(common, word))
+	m.notice, m.noticeErr = strings.Join(matches, " "), false
+}
@@ -3,12 +3,21 @@ package main
 import (
 	"fmt"
 	"os"
+	"path/filepath"
+	"strings"
 
 	"github.com/urfave/cli/v2"
 )
 
 func main() {
-	app := &cli.App{
+	if err := newApp().Run(os.Args); err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
+		os.Exit(1)
+	}
+}
+
+func newApp() *cli.App {
+	return &cli.App{
 		Flags: []cli.Flag{
 			&cli.StringFlag{
 				Name:    "output",
@@ -35,6 +44,10 @@ func main() {
 				Usage:   "Edit a config file live and see the changes in real time.",
 				Aliases: []string{"v"},
 			},
+			&cli.BoolFlag{
+				Name:  "preview-watch",
+				Usage: "with -preview, reloads the config whenever its file changes (eg: saved from another editor)",
+			},
 			&cli.BoolFlag{
 				Name:    "config-create",
 				Usage:   "creates a sample a config file",
@@ -45,15 +58,176 @@ func main() {
 				Usage:   "applies a config file",
 				Aliases: []string{"config-a"},
 			},
+			&cli.IntFlag{
+				Name:    "jobs",
+				Usage:   "number of files to copy at the same time (default depends on the number of CPUs)",
+				Aliases: []string{"j"},
+			},
+			&cli.BoolFlag{
+				Name:  "full",
+				Usage: "organize every file again, even the ones already organized by an earlier run",
+			},
+			&cli.BoolFlag{
+				Name:  "from-stdin",
+				Usage: "organize the files listed on stdin (one per line) instead of searching the current directory",
+			},
+			&cli.BoolFlag{
+				Name:    "null",
+				Usage:   "the files listed on stdin are separated by NUL bytes (eg: find -print0)",
+				Aliases: []string{"0"},
+			},
+		},
+		Commands: []*cli.Command{
+			{
+				Name:      "dupes",
+				Usage:     "lists files with identical contents",
+				ArgsUsage: "[directory]",
+				Action:    dupesActionHandler,
+			},
+			{
+				Name:  "watch",
+				Usage: "keeps organizing new files as they arrive in the current directory",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "config",
+						Usage:   "config file to apply",
+						Value:   "fileo.yaml",
+						Aliases: []string{"c"},
+					},
+					&cli.IntFlag{
+						Name:    "jobs",
+						Usage:   "number of files to copy at the same time",
+						Aliases: []string{"j"},
+					},
+					&cli.BoolFlag{
+						Name:  "initial",
+						Usage: "apply the config to the files already there before watching",
+					},
+				},
+				Action: watchActionHandler,
+			},
+			{
+				Name:  "sync",
+				Usage: "keeps the folders of a config in sync with the files they match, removing the ones that do not match anymore",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "config",
+						Usage:   "config file to sync",
+						Value:   "fileo.yaml",
+						Aliases: []string{"c"},
+					},
+					&cli.BoolFlag{
+						Name:    "dry-run",
+						Usage:   "only show what would change",
+						Aliases: []string{"n"},
+					},
+					&cli.BoolFlag{
+						Name:    "yes",
+						Usage:   "do not ask before applying the changes",
+						Aliases: []string{"y"},
+					},
+					&cli.IntFlag{
+						Name:    "jobs",
+						Usage:   "number of files to copy at the same time",
+						Aliases: []string{"j"},
+					},
+				},
+				Action: syncActionHandler,
+			},
+			{
+				Name:  "preview",
+				Usage: "prints what applying a config would do, without doing it",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "config",
+						Usage:   "config file to preview",
+						Value:   "fileo.yaml",
+						Aliases: []string{"c"},
+					},
+					&cli.StringFlag{
+						Name:  "format",
+						Usage: "output format: tree, json, csv or sh (a shell script doing the same)",
+						Value: "tree",
+					},
+					&cli.BoolFlag{
+						Name:  "full",
+						Usage: "include the files already organized by an earlier run",
+					},
+				},
+				Action: previewActionHandler,
+			},
+			{
+				Name:      "explain",
+				Usage:     "shows how the config decides where a file goes",
+				ArgsUsage: "<file>",
+				Flags: []cli.Flag{
+					&cli.StringFlag{
+						Name:    "config",
+						Usage:   "config file to explain",
+						Value:   "fileo.yaml",
+						Aliases: []string{"c"},
+					},
+					&cli.BoolFlag{
+						Name:  "json",
+						Usage: "print the explanation as JSON",
+					},
+				},
+				Action: explainActionHandler,
+			},
+			{
+				Name:  "config",
+				Usage: "inspects config files",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "coverage",
+						Usage:     "shows how many files every folder of the config matches, and the folders and matchers that are never used",
+						ArgsUsage: "[directory]",
+						Flags: []cli.Flag{
+							&cli.StringFlag{
+								Name:    "config",
+								Usage:   "config file to check",
+								Value:   "fileo.yaml",
+								Aliases: []string{"c"},
+							},
+						},
+						Action: coverageActionHandler,
+					},
+				},
+			},
+			{
+				Name:  "trash",
+				Usage: "lists and restores files that were moved to the trash",
+				Subcommands: []*cli.Command{
+					{
+						Name:      "list",
+						Usage:     "lists the trashed files, most recent first",
+						ArgsUsage: "[path prefix]",
+						Action:    trashListActionHandler,
+					},
+					{
+						Name:      "restore",
+						Usage:     "puts a trashed file back where it was",
+						ArgsUsage: "<original path or trash name>",
+						Action:    trashRestoreActionHandler,
+					},
+				},
+			},
+			{
+				Name:  "state",
+				Usage: "manages what fileo remembers about files it already organized",
+				Subcommands: []*cli.Command{
+					{
+						Name:   "prune",
+						Usage:  "forgets the files whose source does not exist anymore",
+						Action: statePruneActionHandler,
+					},
+				},
+			},
 		},
 		Name:   "fileo",
 		Usage:  "Highly customizable file organizer",
 		Action: cliActionHandler,
 	}
-	if err := app.Run(os.Args); err != nil {
-		fmt.Fprintf(os.Stderr, "Error: %v\n", err)
-		os.Exit(1)
-	}
 }
 
 func cliActionHandler(cCtx *cli.Context) error {
@@ -65,7 +239,7 @@ func cliActionHandler(cCtx *cli.Context) error {
 	outputPath := cCtx.String("output")
 
 	patternSlice := cCtx.StringSlice("pattern")
-	extensionSlice := cCtx.StringSlice("extension")
+	extensionSlice := cCtx.StringSlice("ext")
 
 	recursive := cCtx.Bool("recursive")
 
@@ -80,7 +254,7 @@ func cliActionHandler(cCtx *cli.Context) error {
 		} else if stat.IsDir() {
 			return fmt.Errorf("config filepath must be a directory not a file")
 		}
-		RunLivePreview(previewConfig)
+		RunLivePreview(previewConfig, cCtx.Bool("preview-watch"))
 		return nil
 	}
 
@@ -88,6 +262,17 @@ func cliActionHandler(cCtx *cli.Context) error {
 		return fmt.Errorf("no file output path given")
 	}
 
+	// nil means every file in the current directory
+	var candidates []string
+	if cCtx.Bool("from-stdin") {
+		var err error
+		if candidates, err = readCandidates(os.Stdin, cCtx.Bool("null")); err != nil {
+			return fmt.Errorf("failed to read the files from stdin: %w", err)
+		}
+	} else if cCtx.Bool("null") {
+		return fmt.Errorf("-0 only works together with -from-stdin")
+	}
+
 	if configCreate {
 		if err := os.WriteFile("fileo.yaml", []byte(sampleConfig), 0644); err != nil {
 			return fmt.Errorf("failed to create config file: %w", err)
@@ -95,10 +280,26 @@ func cliActionHandler(cCtx *cli.Context) error {
 		fmt.Println("Created fileo.yaml")
 		return nil
 	} else if configApply {
-		if err := ApplyConfigFromFile("fileo.yaml"); err != nil {
+		state, err := openDefaultState()
+		if err != nil {
+			return fmt.Errorf("failed to load state: %w", err)
+		}
+		opts := applyOptions{jobs: cCtx.Int("jobs"), progress: true, state: state, full: cCtx.Bool("full"), candidates: candidates}
+		if err := ApplyConfigFromFile("fileo.yaml", opts); err != nil {
 			return fmt.Errorf("failed to apply config: %w", err)
 		}
 		return nil
+	} else if candidates != nil {
+		for _, pattern := range patternSlice {
+			if err := OrganizeCandidates(candidates, pattern, outputPath, recursive); err != nil {
+				return err
+			}
+		}
+		for _, extension := range extensionSlice {
+			if err := OrganizeCandidates(candidates, extensionPattern(extension), outputPath, recursive); err != nil {
+				return err
+			}
+		}
 	} else if len(patternSlice) != 0 {
 		var organizeFunction func(string, string)
 
@@ -117,9 +318,9 @@ func cliActionHandler(cCtx *cli.Context) error {
 		var organizeFunction func(string, string)
 
 		if recursive {
-			organizeFunction = OrganizeFilesByExtension
-		} else {
 			organizeFunction = OrganizeFilesByExtensionRecursive
+		} else {
+			organizeFunction = OrganizeFilesByExtension
 		}
 
 		for _, extension := range extensionSlice {
@@ -129,3 +330,125 @@ func cliActionHandler(cCtx *cli.Context) error {
 
 	return nil
 }
+
+func dupesActionHandler(cCtx *cli.Context) error {
+	dir := "."
+	if cCtx.NArg() > 0 {
+		dir = cCtx.Args().Get(0)
+	}
+
+	groups, err := FindDuplicatesInDir(dir)
+	if err != nil {
+		return fmt.Errorf("failed to find duplicates: %w", err)
+	}
+
+	var wasted int64
+	for _, group := range groups {
+		fmt.Printf("%d files of %s each:\n", len(group.Paths), formatBytes(group.Size))
+		for _, file := range group.Paths {
+			fmt.Printf("  %s\n", file)
+		}
+		fmt.Println()
+		wasted += group.Size * int64(len(group.Paths)-1)
+	}
+	fmt.Printf("%s of duplicates, %s could be saved\n", plural(len(groups), "group"), formatBytes(wasted))
+	return nil
+}
+
+func watchActionHandler(cCtx *cli.Context) error {
+	state, err := openDefaultState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	opts := applyOptions{jobs: cCtx.Int("jobs"), state: state}
+	if cCtx.Bool("initial") {
+		if err := ApplyConfigFromFile(cCtx.String("config"), opts); err != nil {
+			return fmt.Errorf("failed to apply config: %w", err)
+		}
+	}
+	return RunWatch(cCtx.String("config"), opts)
+}
+
+func statePruneActionHandler(cCtx *cli.Context) error {
+	state, err := openDefaultState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+
+	pruned := state.prune()
+	if err := state.save(); err != nil {
+		return fmt.Errorf("failed to save state: %w", err)
+	}
+	fmt.Printf("Removed %d entries for files that do not exist anymore\n", pruned)
+	return nil
+}
+
+func syncActionHandler(cCtx *cli.Context) error {
+	opts := applyOptions{jobs: cCtx.Int("jobs"), progress: true}
+	if err := RunSync(cCtx.String("config"), opts, cCtx.Bool("dry-run"), cCtx.Bool("yes")); err != nil {
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	return nil
+}
+
+func previewActionHandler(cCtx *cli.Context) error {
+	if err := RunPreview(cCtx.String("config"), cCtx.String("format"), cCtx.Bool("full")); err != nil {
+		return fmt.Errorf("failed to preview config: %w", err)
+	}
+	return nil
+}
+
+func explainActionHandler(cCtx *cli.Context) error {
+	if cCtx.NArg() == 0 {
+		return fmt.Errorf("no file given to explain")
+	}
+	if err := RunExplain(cCtx.String("config"), cCtx.Args().Get(0), cCtx.Bool("json")); err != nil {
+		return fmt.Errorf("failed to explain: %w", err)
+	}
+	return nil
+}
+
+func coverageActionHandler(cCtx *cli.Context) error {
+	if err := RunCoverage(cCtx.String("config"), cCtx.Args().Get(0)); err != nil {
+		return fmt.Errorf("failed to check coverage: %w", err)
+	}
+	return nil
+}
+
+func trashListActionHandler(cCtx *cli.Context) error {
+	prefix := ""
+	if cCtx.NArg() > 0 {
+		abs, err := filepath.Abs(cCtx.Args().Get(0))
+		if err != nil {
+			return err
+		}
+		prefix = abs
+	}
+
+	items, err := listTrash()
+	if err != nil {
+		return fmt.Errorf("failed to list the trash: %w", err)
+	}
+	for _, item := range items {
+		if strings.HasPrefix(item.Path, prefix) {
+			fmt.Printf("%s  %s  (%s)\n", item.DeletedAt.Format("2006-01-02 15:04:05"), item.Path, item.Name)
+		}
+	}
+	return nil
+}
+
+func trashRestoreActionHandler(cCtx *cli.Context) error {
+	if cCtx.NArg() == 0 {
+		return fmt.Errorf("no file given to restore")
+	}
+
+	item, err := findInTrash(cCtx.Args().Get(0))
+	if err != nil {
+		return err
+	}
+	if err := restoreFromTrash(item); err != nil {
+		return fmt.Errorf("failed to restore: %w", err)
+	}
+	fmt.Printf("Restored %s\n", item.Path)
+	return nil
+}
@@ -0,0 +1,373 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sync"
+	"syscall"
+)
+
+// Operation is a single planned step of organizing: put the file Src at Dst
+type Operation struct {
+	Src    string `json:"source"`
+	Dst    string `json:"destination"`
+	Folder string `json:"folder,omitempty"` // path of the config folder that claimed the file
+	Action string `json:"action,omitempty"` // how the file gets to Dst, empty means copy
+
+	AbsoluteLink bool   `json:"absolute_link,omitempty"` // symlinks point to the absolute path of Src
+	Fallback     string `json:"fallback,omitempty"`      // what to do when a hardlink or reflink can not be made
+
+	priority int // priority of the folder, used by the best policy
+}
+
+// The different ways a file can be put at its destination
+const (
+	actionCopy     = "copy"
+	actionMove     = "move"
+	actionHardlink = "hardlink"
+	actionSymlink  = "symlink"
+	actionReflink  = "reflink"
+)
+
+// What to do when a hardlink or reflink can not be made (eg: across devices)
+const (
+	fallbackCopy    = "copy"
+	fallbackSymlink = "symlink"
+	fallbackFail    = "fail"
+)
+
+// Options for carrying out a plan
+type applyOptions struct {
+	preserve PreserveOptions
+	jobs     int
+	progress bool // show a progress display while running
+
+	deletePermanently bool // overwritten files are destroyed instead of being put in the trash
+
+	state *stateStore // skips what was already organized when set
+	full  bool        // do everything again even if the state says it is up to date
+
+	candidates []string // organize only these files instead of everything in the current directory
+
+	out        io.Writer              // reports and warnings go here instead of stdout and stderr when set
+	onProgress func(*progressTracker) // called once the operations start, for callers showing the progress themselves
+
+	hashContents func(op Operation) bool         // whether the copy for op hashes the contents on the way, for onDone
+	onDone       func(op Operation, hash string) // called by the workers after each successful operation
+}
+
+// Where the reports and warnings of a run go, std is used unless out is set
+func (o applyOptions) output(std *os.File) io.Writer {
+	if o.out != nil {
+		return o.out
+	}
+	return std
+}
+
+// Leaves out what the state says an earlier run already organized, unless there is no state or
+// everything is done again. Also returns how many operations were left out.
+func (o applyOptions) pending(ops []Operation, dedupe string) ([]Operation, int) {
+	if o.state == nil || o.full {
+		return ops, 0
+	}
+	filtered := o.state.filter(ops, dedupe)
+	return filtered, len(ops) - len(filtered)
+}
+
+// Copying is mostly waiting on the disk, so a few more workers than CPUs does not hurt,
+// but past a point it only makes spinning disks seek around more
+func defaultJobs() int {
+	return min(max(runtime.NumCPU(), 2), 8)
+}
+
+// Carries out a single operation. When hashContents asks for it, the hash of the contents is
+// returned when they had to be read for the operation (a regular copy), it is empty otherwise.
+func runOperation(op Operation, opts applyOptions) (string, error) {
+	hash := ""
+	switch op.Action {
+	case actionHardlink:
+		err := replaceWithLink(op.Dst, func(tmp string) error { return os.Link(op.Src, tmp) })
+		if errors.Is(err, syscall.EXDEV) {
+			return runFallback(op, opts, err)
+		}
+		return "", err
+
+	case actionSymlink:
+		target, err := symlinkTarget(op)
+		if err != nil {
+			return "", err
+		}
+		return "", replaceWithLink(op.Dst, func(tmp string) error { return os.Symlink(target, tmp) })
+
+	case actionReflink:
+		if err := reflinkFile(op.Src, filepath.Dir(op.Dst)); err != nil {
+			return runFallback(op, opts, err)
+		}
+
+	case actionMove:
+		if err := os.MkdirAll(filepath.Dir(op.Dst), os.ModePerm); err != nil {
+			return "", err
+		}
+		err := os.Rename(op.Src, op.Dst)
+		if !errors.Is(err, syscall.EXDEV) {
+			return "", err
+		}
+
+		// Rename does not work across devices, copy it over and then remove the original
+		if hash, err = copyForOperation(op, opts); err != nil {
+			return "", err
+		}
+		if err := preserveAttributes(op.Src, op.Dst, opts.preserve); err != nil {
+			fmt.Fprintf(opts.output(os.Stderr), "Warning: %v\n", err)
+		}
+		return hash, os.Remove(op.Src)
+
+	default:
+		var err error
+		if hash, err = copyForOperation(op, opts); err != nil {
+			return "", err
+		}
+	}
+
+	// Attributes we could not keep are only reported, the copy itself is still fine
+	if err := preserveAttributes(op.Src, op.Dst, opts.preserve); err != nil {
+		fmt.Fprintf(opts.output(os.Stderr), "Warning: %v\n", err)
+	}
+	return hash, nil
+}
+
+// Copies the source of op into the folder of its destination
+func copyForOperation(op Operation, opts applyOptions) (string, error) {
+	if opts.hashContents != nil && opts.hashContents(op) {
+		return copyFileHashing(op.Src, filepath.Dir(op.Dst))
+	}
+	return "", copyFile(op.Src, filepath.Dir(op.Dst))
+}
+
+// Used when a hardlink or reflink could not be made
+func runFallback(op Operation, opts applyOptions, err error) (string, error) {
+	switch op.Fallback {
+	case fallbackFail:
+		return "", err
+	case fallbackSymlink:
+		op.Action = actionSymlink
+	default:
+		op.Action = actionCopy
+	}
+	return runOperation(op, opts)
+}
+
+// Where a symlink for op should point to. Relative links keep working when the source and the
+// organized folders are moved together, absolute ones when only the organized folders are moved.
+func symlinkTarget(op Operation) (string, error) {
+	if op.AbsoluteLink {
+		return filepath.Abs(op.Src)
+	}
+	absSrc, err := filepath.Abs(op.Src)
+	if err != nil {
+		return "", err
+	}
+	absDstDir, err := filepath.Abs(filepath.Dir(op.Dst))
+	if err != nil {
+		return "", err
+	}
+	return filepath.Rel(absDstDir, absSrc)
+}
+
+// When the same file is moved into several folders only the last one can actually move it,
+// the others have to copy it before that happens
+func resolveMoves(ops []Operation) []Operation {
+	lastMove := map[string]int{}
+	for i, op := range ops {
+		if op.Action == actionMove {
+			lastMove[op.Src] = i
+		}
+	}
+
+	resolved := []Operation{}
+	for i, op := range ops {
+		if op.Action == actionMove && lastMove[op.Src] != i {
+			op.Action = actionCopy
+		}
+		resolved = append(resolved, op)
+	}
+	return resolved
+}
+
+// Creates a link at dst through create, replacing whatever is there. The link is made under a
+// temporary name first and renamed over dst, the same way copies are.
+func replaceWithLink(dst string, create func(tmp string) error) error {
+	if err := os.MkdirAll(filepath.Dir(dst), os.ModePerm); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(dst), "."+filepath.Base(dst)+".fileo-*")
+	if err != nil {
+		return err
+	}
+	tmp.Close()
+	os.Remove(tmp.Name())
+
+	if err := create(tmp.Name()); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp.Name(), dst); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
+
+// Puts whatever is at the destination of a group in the trash before it gets written over. Only
+// what was there before this run counts, so the group is checked as a whole against its last
+// operation (the one that decides what ends up there). Files that are identical already are
+// simply written over.
+func trashReplaced(group []Operation, opts applyOptions) error {
+	last := group[len(group)-1]
+	if opts.deletePermanently {
+		return nil
+	}
+
+	info, err := os.Lstat(last.Dst)
+	if err != nil || info.IsDir() || destinationUpToDate(last) {
+		return nil
+	}
+	return moveToTrash(last.Dst)
+}
+
+// Groups the operations by destination, keeping the groups in plan order
+func groupByDestination(ops []Operation) [][]Operation {
+	groups := [][]Operation{}
+	groupIndex := map[string]int{}
+	for _, op := range ops {
+		i, ok := groupIndex[op.Dst]
+		if !ok {
+			i = len(groups)
+			groupIndex[op.Dst] = i
+			groups = append(groups, []Operation{})
+		}
+		groups[i] = append(groups[i], op)
+	}
+	return groups
+}
+
+// A group of operations handed to a worker
+type planJob struct {
+	ops   []Operation
+	phase *sync.WaitGroup
+}
+
+// Runs the operations of a plan on a pool of workers. Operations writing to the same destination
+// are always handled by one worker in the order they were planned, so the end result does not
+// depend on how the workers get scheduled. Operations that read another planned destination
+// (eg: a link to an earlier copy) only start once everything else is done, and moves go last
+// so their source is still there for anything else reading it.
+func executePlan(ops []Operation, opts applyOptions) error {
+	if opts.jobs < 1 {
+		opts.jobs = defaultJobs()
+	}
+
+	destinations := map[string]bool{}
+	for _, op := range ops {
+		destinations[op.Dst] = true
+	}
+	phaseOps := make([][]Operation, 3)
+	for _, op := range ops {
+		switch {
+		case op.Action == actionMove:
+			phaseOps[2] = append(phaseOps[2], op)
+		case destinations[op.Src]:
+			phaseOps[1] = append(phaseOps[1], op)
+		default:
+			phaseOps[0] = append(phaseOps[0], op)
+		}
+	}
+	phases := [][][]Operation{}
+	for _, phase := range phaseOps {
+		phases = append(phases, groupByDestination(phase))
+	}
+
+	tracker := newProgressTracker(ops)
+	if opts.onProgress != nil {
+		opts.onProgress(tracker)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	work := make(chan planJob)
+	var (
+		wg   sync.WaitGroup
+		mu   sync.Mutex
+		errs []error
+	)
+	for range min(opts.jobs, max(len(ops), 1)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range work {
+				if err := trashReplaced(job.ops, opts); err != nil {
+					mu.Lock()
+					errs = append(errs, err)
+					mu.Unlock()
+					tracker.skipped(job.ops)
+					job.phase.Done()
+					continue
+				}
+
+				for _, op := range job.ops {
+					if ctx.Err() != nil {
+						break
+					}
+					if hash, err := runOperation(op, opts); err != nil {
+						mu.Lock()
+						errs = append(errs, fmt.Errorf("%s -> %s: %w", op.Src, op.Dst, err))
+						mu.Unlock()
+					} else if opts.onDone != nil {
+						opts.onDone(op, hash)
+					}
+					tracker.done(op)
+				}
+				job.phase.Done()
+			}
+		}()
+	}
+
+	finished := make(chan struct{})
+	go func() {
+		defer close(work)
+		for _, groups := range phases {
+			var phase sync.WaitGroup
+			for _, group := range groups {
+				phase.Add(1)
+				select {
+				case work <- planJob{ops: group, phase: &phase}:
+				case <-ctx.Done():
+					phase.Done()
+					return
+				}
+			}
+			phase.Wait()
+		}
+	}()
+	go func() {
+		wg.Wait()
+		close(finished)
+	}()
+
+	if opts.progress {
+		if interrupted := showProgress(tracker, finished); interrupted {
+			cancel()
+			<-finished
+			errs = append(errs, errors.New("interrupted"))
+		}
+	} else {
+		<-finished
+	}
+
+	return errors.Join(errs...)
+}
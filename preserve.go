@@ -0,0 +1,122 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// PreserveOptions controls which attributes of a source file are carried over to its copy.
+// In the config it can be written as a single value (eg: preserve: all) or as a list
+// (eg: preserve: [mode, times, xattrs])
+type PreserveOptions struct {
+	Mode   bool
+	Times  bool
+	Owner  bool
+	Xattrs bool
+}
+
+// By default we keep the permission bits and timestamps, the same as `cp -p` minus the owner.
+// Owner usually needs root and xattrs are not supported everywhere so those are opt in.
+var defaultPreserve = PreserveOptions{Mode: true, Times: true}
+
+// Error for a single attribute we were not able to carry over to the copied file
+type PreserveError struct {
+	Attribute string
+	Path      string
+	Err       error
+}
+
+func (e *PreserveError) Error() string {
+	return fmt.Sprintf("could not preserve %s on %s: %v", e.Attribute, e.Path, e.Err)
+}
+
+func (e *PreserveError) Unwrap() error {
+	return e.Err
+}
+
+// Builds the options from a list of attribute names
+func parsePreserve(values []string) (PreserveOptions, error) {
+	var p PreserveOptions
+	for _, value := range values {
+		switch strings.ToLower(strings.TrimSpace(value)) {
+		case "mode":
+			p.Mode = true
+		case "times", "timestamps":
+			p.Times = true
+		case "owner", "ownership":
+			p.Owner = true
+		case "xattrs", "xattr":
+			p.Xattrs = true
+		case "all":
+			p = PreserveOptions{Mode: true, Times: true, Owner: true, Xattrs: true}
+		case "none":
+			p = PreserveOptions{}
+		default:
+			return p, fmt.Errorf("unknown preserve option %q (expected mode, times, owner, xattrs, all or none)", value)
+		}
+	}
+	return p, nil
+}
+
+func (p *PreserveOptions) UnmarshalYAML(value *yaml.Node) error {
+	var values []string
+	switch value.Kind {
+	case yaml.ScalarNode:
+		values = []string{value.Value}
+	case yaml.SequenceNode:
+		if err := value.Decode(&values); err != nil {
+			return err
+		}
+	default:
+		return fmt.Errorf("line %d: preserve must be a value or a list of values", value.Line)
+	}
+
+	parsed, err := parsePreserve(values)
+	if err != nil {
+		return fmt.Errorf("line %d: %w", value.Line, err)
+	}
+	*p = parsed
+	return nil
+}
+
+// Applies the requested attributes of src onto dst. This is done after the contents are copied.
+// It does not stop at the first failure, every attribute that could not be preserved is
+// reported in the returned error.
+func preserveAttributes(src, dst string, p PreserveOptions) error {
+	info, err := os.Lstat(src)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	addErr := func(attribute string, err error) {
+		if err != nil {
+			errs = append(errs, &PreserveError{Attribute: attribute, Path: dst, Err: err})
+		}
+	}
+
+	// Owner has to go first since chown can clear the setuid/setgid bits
+	if p.Owner {
+		addErr("owner", preserveOwner(dst, info))
+	}
+
+	if p.Mode {
+		mode := info.Mode() & (os.ModePerm | os.ModeSetuid | os.ModeSetgid | os.ModeSticky)
+		addErr("mode", os.Chmod(dst, mode))
+	}
+
+	if p.Xattrs {
+		addErr("xattrs", preserveXattrs(src, dst))
+	}
+
+	// Times go last, anything else we touch could bump them
+	if p.Times {
+		addErr("times", os.Chtimes(dst, fileAccessTime(info), info.ModTime()))
+	}
+
+	return errors.Join(errs...)
+}
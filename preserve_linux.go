@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+func preserveOwner(dst string, info os.FileInfo) error {
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return errors.ErrUnsupported
+	}
+	return os.Lchown(dst, int(stat.Uid), int(stat.Gid))
+}
+
+// Copies every extended attribute of src onto dst
+func preserveXattrs(src, dst string) error {
+	size, err := unix.Llistxattr(src, nil)
+	if err != nil || size == 0 {
+		return err
+	}
+	names := make([]byte, size)
+	size, err = unix.Llistxattr(src, names)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, name := range bytes.Split(names[:size], []byte{0}) {
+		if len(name) == 0 {
+			continue
+		}
+		attr := string(name)
+
+		valueSize, err := unix.Lgetxattr(src, attr, nil)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		value := make([]byte, valueSize)
+		valueSize, err = unix.Lgetxattr(src, attr, value)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+
+		if err := unix.Lsetxattr(dst, attr, value[:valueSize], 0); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
+}
+
+func fileAccessTime(info os.FileInfo) time.Time {
+	if stat, ok := info.Sys().(*syscall.Stat_t); ok {
+		return time.Unix(stat.Atim.Unix())
+	}
+	return info.ModTime()
+}
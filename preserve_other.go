@@ -0,0 +1,23 @@
+//go:build !linux
+
+package main
+
+import (
+	"errors"
+	"os"
+	"time"
+)
+
+// Owner and xattrs are only carried over on linux for now
+
+func preserveOwner(dst string, info os.FileInfo) error {
+	return errors.ErrUnsupported
+}
+
+func preserveXattrs(src, dst string) error {
+	return errors.ErrUnsupported
+}
+
+func fileAccessTime(info os.FileInfo) time.Time {
+	return info.ModTime()
+}
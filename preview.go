@@ -0,0 +1,195 @@
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"slices"
+	"strings"
+)
+
+// The formats a plan can be printed in by the preview command
+var previewFormats = []string{"tree", "json", "csv", "sh"}
+
+// A directory of the destination tree, with the files that end up directly in it
+type planDir struct {
+	dirs  map[string]*planDir
+	files []string
+}
+
+// Prints the destinations of a plan as a tree, like the diagram of the README
+func renderPlanTree(w io.Writer, plan Plan) {
+	root := &planDir{dirs: map[string]*planDir{}}
+	for _, op := range plan.Operations {
+		dir := root
+		parts := strings.Split(op.Dst, "/")
+		for _, part := range parts[:len(parts)-1] {
+			if dir.dirs[part] == nil {
+				dir.dirs[part] = &planDir{dirs: map[string]*planDir{}}
+			}
+			dir = dir.dirs[part]
+		}
+
+		dir.files = append(dir.files, parts[len(parts)-1]+actionMarkers[op.Action])
+	}
+	renderPlanDir(w, root, "")
+
+	if len(plan.Unmatched) > 0 {
+		fmt.Fprintf(w, "\n%s not matching any folder\n", plural(len(plan.Unmatched), "file"))
+	}
+}
+
+func renderPlanDir(w io.Writer, dir *planDir, indent string) {
+	// Directories first, then the files, each sorted by name
+	names := make([]string, 0, len(dir.dirs))
+	for name := range dir.dirs {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	files := slices.Clone(dir.files)
+	slices.Sort(files)
+
+	total := len(names) + len(files)
+	for i, name := range append(names, files...) {
+		branch, nextIndent := "├── ", indent+"│   "
+		if i == total-1 {
+			branch, nextIndent = "└── ", indent+"    "
+		}
+
+		if i < len(names) {
+			fmt.Fprintf(w, "%s%s%s/\n", indent, branch, name)
+			renderPlanDir(w, dir.dirs[name], nextIndent)
+		} else {
+			fmt.Fprintf(w, "%s%s%s\n", indent, branch, name)
+		}
+	}
+}
+
+func renderPlanJSON(w io.Writer, plan Plan) error {
+	encoder := json.NewEncoder(w)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(plan)
+}
+
+func renderPlanCSV(w io.Writer, plan Plan) error {
+	writer := csv.NewWriter(w)
+	writer.Write([]string{"action", "source", "destination"})
+	for _, op := range plan.Operations {
+		writer.Write([]string{op.Action, op.Src, op.Dst})
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Quotes a path for a POSIX shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// Prints a shell script doing what applying the plan would. Files that are written over are not
+// trashed by the script, and the copies only keep the mode and times (with cp -p) when the config
+// preserves any attributes.
+func renderPlanScript(w io.Writer, plan Plan, preserve PreserveOptions) error {
+	fmt.Fprintln(w, "#!/bin/sh")
+	fmt.Fprintln(w, "# What applying the config would do, generated by fileo preview")
+	fmt.Fprintln(w, "set -e")
+
+	cp := "cp"
+	if preserve != (PreserveOptions{}) {
+		cp = "cp -p"
+	}
+
+	// Moves go last so the copies of the same files still find them
+	ops := []Operation{}
+	for _, op := range plan.Operations {
+		if op.Action != actionMove {
+			ops = append(ops, op)
+		}
+	}
+	for _, op := range plan.Operations {
+		if op.Action == actionMove {
+			ops = append(ops, op)
+		}
+	}
+
+	madeDirs := map[string]bool{}
+	for _, op := range ops {
+		if dir := path.Dir(op.Dst); !madeDirs[dir] {
+			fmt.Fprintf(w, "mkdir -p %s\n", shellQuote(dir))
+			madeDirs[dir] = true
+		}
+
+		src, dst := shellQuote(op.Src), shellQuote(op.Dst)
+		switch op.Action {
+		case actionMove:
+			fmt.Fprintf(w, "mv -f -- %s %s\n", src, dst)
+		case actionHardlink:
+			fmt.Fprintf(w, "ln -f -- %s %s\n", src, dst)
+		case actionSymlink:
+			target, err := symlinkTarget(op)
+			if err != nil {
+				return err
+			}
+			fmt.Fprintf(w, "ln -sf -- %s %s\n", shellQuote(target), dst)
+		default:
+			// Reflinks are plain copies here, there is no portable way to ask for one
+			fmt.Fprintf(w, "%s -f -- %s %s\n", cp, src, dst)
+		}
+	}
+	return nil
+}
+
+// Plans the config the same way applyPlan would run it: what the state says is already organized
+// is left out (unless opts.full), and the rest is deduplicated. Also returns how many operations
+// the state left out.
+func previewPlan(data ConfigData, opts applyOptions) (Plan, int, error) {
+	plan, err := planConfig(data, opts.candidates)
+	if err != nil {
+		return plan, 0, err
+	}
+
+	ops, skipped := opts.pending(plan.Operations, data.Dedupe)
+	if plan.Operations, _, err = dedupeOperations(ops, data.Dedupe); err != nil {
+		return plan, 0, err
+	}
+	return plan, skipped, nil
+}
+
+// RunPreview prints what applying the config would do in one of the previewFormats. With full,
+// the files an earlier run already organized are included too, like -full does when applying.
+func RunPreview(configPath, format string, full bool) error {
+	if !slices.Contains(previewFormats, format) {
+		return fmt.Errorf("unknown format %q (expected %s)", format, strings.Join(previewFormats, ", "))
+	}
+
+	data, err := loadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	state, err := openDefaultState()
+	if err != nil {
+		return fmt.Errorf("failed to load state: %w", err)
+	}
+	plan, skipped, err := previewPlan(data, applyOptions{state: state, full: full})
+	if err != nil {
+		return err
+	}
+	// Not on stdout, the other formats are meant to be read by programs
+	if skipped > 0 {
+		fmt.Fprintf(os.Stderr, "Leaving out %s already organized (use -full to include them)\n", plural(skipped, "file"))
+	}
+
+	switch format {
+	case "json":
+		return renderPlanJSON(os.Stdout, plan)
+	case "csv":
+		return renderPlanCSV(os.Stdout, plan)
+	case "sh":
+		return renderPlanScript(os.Stdout, plan, data.Preserve)
+	}
+	renderPlanTree(os.Stdout, plan)
+	return nil
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/charmbracelet/bubbles/progress"
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/mattn/go-isatty"
+)
+
+// How often the progress gets refreshed. Log lines are printed less often than the tui redraws.
+const (
+	progressTickInterval = 100 * time.Millisecond
+	progressLogInterval  = 5 * time.Second
+)
+
+// Keeps count of how far along a plan is. The counters are updated by the workers.
+type progressTracker struct {
+	totalFiles int64
+	totalBytes int64
+	doneFiles  atomic.Int64
+	doneBytes  atomic.Int64
+	sizes      map[string]int64
+	start      time.Time
+}
+
+func newProgressTracker(ops []Operation) *progressTracker {
+	t := &progressTracker{
+		totalFiles: int64(len(ops)),
+		sizes:      map[string]int64{},
+		start:      time.Now(),
+	}
+	for _, op := range ops {
+		if _, ok := t.sizes[op.Src]; ok {
+			t.totalBytes += t.sizes[op.Src]
+			continue
+		}
+		if info, err := os.Stat(op.Src); err == nil {
+			t.sizes[op.Src] = info.Size()
+			t.totalBytes += info.Size()
+		}
+	}
+	return t
+}
+
+func (t *progressTracker) done(op Operation) {
+	t.doneFiles.Add(1)
+	t.doneBytes.Add(t.sizes[op.Src])
+}
+
+// Operations that will not run at all still count as done for the progress
+func (t *progressTracker) skipped(ops []Operation) {
+	for _, op := range ops {
+		t.done(op)
+	}
+}
+
+func (t *progressTracker) fraction() float64 {
+	if t.totalBytes > 0 {
+		return float64(t.doneBytes.Load()) / float64(t.totalBytes)
+	}
+	if t.totalFiles > 0 {
+		return float64(t.doneFiles.Load()) / float64(t.totalFiles)
+	}
+	return 1
+}
+
+// eg: "12/340 files  1.2 GiB/3.4 GiB  56.1 MiB/s  ETA 40s"
+func (t *progressTracker) String() string {
+	doneBytes := t.doneBytes.Load()
+	elapsed := time.Since(t.start)
+
+	var throughput float64
+	if elapsed > 0 {
+		throughput = float64(doneBytes) / elapsed.Seconds()
+	}
+
+	eta := "?"
+	if throughput > 0 {
+		remaining := time.Duration(float64(t.totalBytes-doneBytes) / throughput * float64(time.Second))
+		eta = remaining.Round(time.Second).String()
+	}
+
+	return fmt.Sprintf("%d/%d files  %s/%s  %s/s  ETA %s",
+		t.doneFiles.Load(), t.totalFiles,
+		formatBytes(doneBytes), formatBytes(t.totalBytes),
+		formatBytes(int64(throughput)), eta)
+}
+
+// Shows the progress until finished is closed. Uses a bubbletea progress bar when stdout is a
+// terminal and periodic log lines otherwise. Returns true if the user interrupted the run.
+func showProgress(t *progressTracker, finished <-chan struct{}) bool {
+	if !isatty.IsTerminal(os.Stdout.Fd()) {
+		ticker := time.NewTicker(progressLogInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				log.Println(t)
+			case <-finished:
+				log.Printf("Organized %s (%s) in %s\n", plural(int(t.doneFiles.Load()), "file"), formatBytes(t.doneBytes.Load()), time.Since(t.start).Round(time.Millisecond))
+				return false
+			}
+		}
+	}
+
+	p := tea.NewProgram(progressModel{tracker: t, bar: progress.New(progress.WithDefaultGradient())})
+	go func() {
+		<-finished
+		p.Send(progressDoneMsg{})
+	}()
+
+	m, err := p.Run()
+	if err != nil {
+		<-finished
+		return false
+	}
+	return m.(progressModel).interrupted
+}
+
+type progressTickMsg struct{}
+type progressDoneMsg struct{}
+
+type progressModel struct {
+	tracker     *progressTracker
+	bar         progress.Model
+	finished    bool
+	interrupted bool
+}
+
+func progressTick() tea.Cmd {
+	return tea.Tick(progressTickInterval, func(time.Time) tea.Msg { return progressTickMsg{} })
+}
+
+func (m progressModel) Init() tea.Cmd {
+	return progressTick()
+}
+
+func (m progressModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.KeyMsg:
+		if msg.String() == "ctrl+c" {
+			m.interrupted = true
+			return m, tea.Quit
+		}
+	case tea.WindowSizeMsg:
+		m.bar.Width = min(msg.Width-4, 80)
+	case progressTickMsg:
+		return m, progressTick()
+	case progressDoneMsg:
+		m.finished = true
+		return m, tea.Quit
+	}
+	return m, nil
+}
+
+func (m progressModel) View() string {
+	var b strings.Builder
+	b.WriteString(m.bar.ViewAs(m.tracker.fraction()) + "\n")
+	b.WriteString(m.tracker.String() + "\n")
+	if m.finished {
+		b.WriteString(fmt.Sprintf("Done in %s\n", time.Since(m.tracker.start).Round(time.Millisecond)))
+	}
+	return b.String()
+}
+
+// A count followed by the word, in plural unless there is one (eg: 1 file, 2 files)
+func plural(n int, word string) string {
+	if n == 1 {
+		return "1 " + word
+	}
+	return fmt.Sprintf("%d %ss", n, word)
+}
+
+// Human readable size (eg: 1.5 MiB)
+func formatBytes(n int64) string {
+	const unit = 1024
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for m := n / unit; m >= unit; m /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGTPE"[exp])
+}
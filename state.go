@@ -0,0 +1,234 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"time"
+)
+
+// What we know about a file that was already organized
+type stateEntry struct {
+	Source      string    `json:"source"`
+	Destination string    `json:"destination"`
+	Folder      string    `json:"folder,omitempty"`
+	Size        int64     `json:"size"`
+	ModTime     time.Time `json:"mtime"`
+	Hash        string    `json:"hash"`              // empty when the contents were never read (eg: a reflink)
+	Skipped     bool      `json:"skipped,omitempty"` // a duplicate skipped by dedupe, it has no destination
+}
+
+// Remembers which files were organized where, so the next run can skip the ones that did not
+// change. It is stored as a JSON-lines file with one entry per source and destination.
+type stateStore struct {
+	path    string
+	mu      sync.Mutex
+	entries map[string]stateEntry
+}
+
+// Where the state is kept by default: $XDG_STATE_HOME/fileo/state.jsonl (or ~/.local/state/fileo)
+func defaultStatePath() (string, error) {
+	dir := os.Getenv("XDG_STATE_HOME")
+	if dir == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dir = filepath.Join(home, ".local", "state")
+	}
+	return filepath.Join(dir, "fileo", "state.jsonl"), nil
+}
+
+// Loads the state from its default location
+func openDefaultState() (*stateStore, error) {
+	statePath, err := defaultStatePath()
+	if err != nil {
+		return nil, err
+	}
+	return loadState(statePath)
+}
+
+func stateKey(src, dst string) string {
+	return src + "\x00" + dst
+}
+
+// Loads the state from a file, a missing file is just an empty state
+func loadState(fileName string) (*stateStore, error) {
+	s := &stateStore{path: fileName, entries: map[string]stateEntry{}}
+
+	f, err := os.Open(fileName)
+	if errors.Is(err, os.ErrNotExist) {
+		return s, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry stateEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%s:%d: %w", fileName, line, err)
+		}
+		s.entries[stateKey(entry.Source, entry.Destination)] = entry
+	}
+	return s, scanner.Err()
+}
+
+// Writes the state back to its file, replacing it atomically
+func (s *stateStore) save() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(s.path), os.ModePerm); err != nil {
+		return err
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(s.path), ".state-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	// Sorted so the file does not change between runs for no reason
+	keys := make([]string, 0, len(s.entries))
+	for key := range s.entries {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	w := bufio.NewWriter(tmp)
+	encoder := json.NewEncoder(w)
+	for _, key := range keys {
+		if err := encoder.Encode(s.entries[key]); err != nil {
+			tmp.Close()
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), s.path)
+}
+
+// Whether op was already done by an earlier run and its source did not change since. Skipped
+// duplicates only stay done while duplicates are still skipped.
+func (s *stateStore) upToDate(op Operation, dedupe string) bool {
+	src, err := filepath.Abs(op.Src)
+	if err != nil {
+		return false
+	}
+	dst, err := filepath.Abs(op.Dst)
+	if err != nil {
+		return false
+	}
+
+	s.mu.Lock()
+	entry, ok := s.entries[stateKey(src, dst)]
+	s.mu.Unlock()
+	if !ok {
+		return false
+	}
+
+	// The organized file was removed, so it has to be done again
+	if entry.Skipped {
+		if dedupe != dedupeSkip {
+			return false
+		}
+	} else if _, err := os.Lstat(dst); err != nil {
+		return false
+	}
+
+	info, err := os.Stat(src)
+	if err != nil || info.Size() != entry.Size {
+		return false
+	}
+	if info.ModTime().Equal(entry.ModTime) {
+		return true
+	}
+
+	// Only the time changed (eg: touched or copied around), check the contents
+	if entry.Hash == "" {
+		return false
+	}
+	hash, err := hashFile(src, -1)
+	if err != nil || hash != entry.Hash {
+		return false
+	}
+	s.mu.Lock()
+	entry.ModTime = info.ModTime()
+	s.entries[stateKey(src, dst)] = entry
+	s.mu.Unlock()
+	return true
+}
+
+// Leaves out the operations that are already up to date
+func (s *stateStore) filter(ops []Operation, dedupe string) []Operation {
+	filtered := []Operation{}
+	for _, op := range ops {
+		if !s.upToDate(op, dedupe) {
+			filtered = append(filtered, op)
+		}
+	}
+	return filtered
+}
+
+// Works out what to remember about op once it is done. This has to look at the source before
+// the operation runs since a move makes it disappear. The hash is only known here when the source
+// did not change since it was last recorded, otherwise the copy works it out (see copyFileHashing).
+func (s *stateStore) entryFor(op Operation) (stateEntry, error) {
+	src, err := filepath.Abs(op.Src)
+	if err != nil {
+		return stateEntry{}, err
+	}
+	dst, err := filepath.Abs(op.Dst)
+	if err != nil {
+		return stateEntry{}, err
+	}
+	info, err := os.Stat(src)
+	if err != nil {
+		return stateEntry{}, err
+	}
+	entry := stateEntry{Source: src, Destination: dst, Folder: op.Folder, Size: info.Size(), ModTime: info.ModTime()}
+
+	s.mu.Lock()
+	known, ok := s.entries[stateKey(src, dst)]
+	s.mu.Unlock()
+	if ok && known.Size == entry.Size && known.ModTime.Equal(entry.ModTime) {
+		entry.Hash = known.Hash
+	}
+	return entry, nil
+}
+
+func (s *stateStore) record(entry stateEntry) {
+	s.mu.Lock()
+	s.entries[stateKey(entry.Source, entry.Destination)] = entry
+	s.mu.Unlock()
+}
+
+// Drops the entries whose source file does not exist anymore and returns how many were dropped
+func (s *stateStore) prune() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	pruned := 0
+	for key, entry := range s.entries {
+		if _, err := os.Lstat(entry.Source); errors.Is(err, os.ErrNotExist) {
+			delete(s.entries, key)
+			pruned++
+		}
+	}
+	return pruned
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+)
+
+// Options for sync mode, under the sync key of the config
+type SyncOptions struct {
+	Trash string `yaml:"trash"` // removed files are moved into this folder instead of the system trash
+}
+
+// What a sync has to do to make the managed folders match the config again
+type syncDiff struct {
+	add    []Operation
+	update []Operation
+	remove []string // files in the managed folders that nothing maps to anymore
+}
+
+func (d syncDiff) empty() bool {
+	return len(d.add) == 0 && len(d.update) == 0 && len(d.remove) == 0
+}
+
+// The folders a config writes into: its top level folders and the unmatched folder. These are
+// the ones sync manages.
+func managedDirs(data ConfigData) []string {
+	dirs := []string{}
+	for _, folder := range data.Folders {
+		dirs = append(dirs, path.Clean(folder.Name))
+	}
+	if data.Unmatched != "" {
+		dirs = append(dirs, path.Clean(filepath.ToSlash(data.Unmatched)))
+	}
+	return dirs
+}
+
+func insideDirs(file string, dirs []string) bool {
+	return slices.ContainsFunc(dirs, func(dir string) bool {
+		return file == dir || strings.HasPrefix(file, dir+"/")
+	})
+}
+
+// Whether the file at op.Dst already is what op would put there
+func destinationUpToDate(op Operation) bool {
+	dstInfo, err := os.Lstat(op.Dst)
+	if err != nil {
+		return false
+	}
+
+	switch op.Action {
+	case actionSymlink:
+		target, err := os.Readlink(op.Dst)
+		expected, err2 := symlinkTarget(op)
+		return err == nil && err2 == nil && target == expected
+	case actionHardlink:
+		srcInfo, err := os.Stat(op.Src)
+		return err == nil && os.SameFile(srcInfo, dstInfo)
+	}
+
+	srcInfo, err := os.Stat(op.Src)
+	if err != nil || !dstInfo.Mode().IsRegular() || srcInfo.Size() != dstInfo.Size() {
+		return false
+	}
+	if srcInfo.ModTime().Equal(dstInfo.ModTime()) {
+		return true
+	}
+
+	// Times are not preserved (or the file was touched), so compare the contents
+	srcHash, err := hashFile(op.Src, -1)
+	if err != nil {
+		return false
+	}
+	dstHash, err := hashFile(op.Dst, -1)
+	return err == nil && srcHash == dstHash
+}
+
+// Works out what sync has to do. The files already inside the managed folders are never used as
+// sources, otherwise every organized file would keep itself alive.
+func planSync(data ConfigData) (syncDiff, error) {
+	var diff syncDiff
+
+	if slices.ContainsFunc(data.Folders, folderMoves) {
+		return diff, errors.New("sync can not be used with folders that move files, the sources would disappear")
+	}
+
+	managed := managedDirs(data)
+	trash := []string{}
+	if data.Sync.Trash != "" {
+		trash = append(trash, path.Clean(filepath.ToSlash(data.Sync.Trash)))
+	}
+	excluded := append(slices.Clone(managed), trash...)
+
+	all, err := listCandidates()
+	if err != nil {
+		return diff, err
+	}
+	candidates := []string{}
+	for _, candidate := range all {
+		if !insideDirs(candidate, excluded) {
+			candidates = append(candidates, candidate)
+		}
+	}
+
+	plan, err := planConfig(data, candidates)
+	if err != nil {
+		return diff, err
+	}
+	// The same way applying does it, or skipped duplicates would be added again by every sync
+	ops, _, err := dedupeOperations(plan.Operations, data.Dedupe)
+	if err != nil {
+		return diff, err
+	}
+
+	planned := map[string]bool{}
+	for _, op := range ops {
+		planned[path.Clean(op.Dst)] = true
+
+		if _, err := os.Lstat(op.Dst); errors.Is(err, os.ErrNotExist) {
+			diff.add = append(diff.add, op)
+		} else if !destinationUpToDate(op) {
+			diff.update = append(diff.update, op)
+		}
+	}
+
+	for _, dir := range managed {
+		// Walking the current directory would remove every source (parseConfig refuses such names)
+		if dir == "." {
+			continue
+		}
+		err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+			if errors.Is(err, os.ErrNotExist) && file == dir {
+				return fs.SkipDir
+			} else if err != nil {
+				return err
+			}
+			file = filepath.ToSlash(file)
+			if d.IsDir() && insideDirs(file, trash) {
+				return fs.SkipDir
+			}
+			if !d.IsDir() && !planned[file] && file != data.configFile {
+				diff.remove = append(diff.remove, file)
+			}
+			return nil
+		})
+		if err != nil {
+			return diff, err
+		}
+	}
+	return diff, nil
+}
+
+func folderMoves(folder Folder) bool {
+	return folder.Action == actionMove || slices.ContainsFunc(folder.ChildFolders, folderMoves)
+}
+
+// Prints the diff, the same way for a dry run and before applying it
+func (d syncDiff) print(w io.Writer) {
+	for _, op := range d.add {
+		fmt.Fprintf(w, "+ %s (from %s)\n", op.Dst, op.Src)
+	}
+	for _, op := range d.update {
+		fmt.Fprintf(w, "~ %s (from %s)\n", op.Dst, op.Src)
+	}
+	for _, file := range d.remove {
+		fmt.Fprintf(w, "- %s\n", file)
+	}
+	fmt.Fprintf(w, "%d to add, %d to update, %d to remove\n", len(d.add), len(d.update), len(d.remove))
+}
+
+// Removes a file that sync does not manage anymore, into the trash folder of the config when
+// there is one and the system trash otherwise. Directories left empty behind it are removed too.
+func removeManaged(file string, data ConfigData) error {
+	switch {
+	case data.Sync.Trash != "":
+		trashed := filepath.Join(data.Sync.Trash, filepath.FromSlash(file))
+		if err := os.MkdirAll(filepath.Dir(trashed), os.ModePerm); err != nil {
+			return err
+		}
+		if err := os.Rename(file, trashed); err != nil {
+			return err
+		}
+	case data.DeletePermanently:
+		if err := os.Remove(file); err != nil {
+			return err
+		}
+	default:
+		if err := moveToTrash(file); err != nil {
+			return err
+		}
+	}
+
+	for dir := path.Dir(file); dir != "." && dir != "/"; dir = path.Dir(dir) {
+		if os.Remove(dir) != nil {
+			break
+		}
+	}
+	return nil
+}
+
+// Carries out a sync diff
+func applySync(data ConfigData, diff syncDiff, opts applyOptions) error {
+	// Updated files are simply written over, the same way as new ones
+	ops := append(slices.Clone(diff.add), diff.update...)
+	err := applyPlan(data, ops, opts)
+
+	for _, file := range diff.remove {
+		if removeErr := removeManaged(file, data); removeErr != nil {
+			err = errors.Join(err, removeErr)
+		}
+	}
+	return err
+}
+
+// RunSync makes the folders of a config match its sources: new matches are added, changed ones
+// updated and files that do not map to a source anymore are removed. The diff is always shown
+// first, and nothing is changed on a dry run or when the user does not confirm.
+func RunSync(configPath string, opts applyOptions, dryRun, yes bool) error {
+	data, err := loadConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+
+	diff, err := planSync(data)
+	if err != nil {
+		return err
+	}
+	diff.print(os.Stdout)
+	if diff.empty() || dryRun {
+		return nil
+	}
+
+	if !yes {
+		fmt.Print("Apply these changes? [y/N] ")
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if a := strings.ToLower(strings.TrimSpace(answer)); a != "y" && a != "yes" {
+			fmt.Println("Nothing was changed")
+			return nil
+		}
+	}
+	return applySync(data, diff, opts)
+}
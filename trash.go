@@ -0,0 +1,346 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io/fs"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// Files we would otherwise destroy (overwritten destinations, files removed by sync) go to the
+// trash, following the FreeDesktop.org trash spec so file managers can show and restore them:
+// https://specifications.freedesktop.org/trash-spec/latest/
+
+const trashInfoDateFormat = "2006-01-02T15:04:05"
+
+// A file sitting in a trash directory
+type trashedItem struct {
+	Name      string    // name under files/ (and info/ with .trashinfo)
+	Path      string    // where it was before being trashed
+	DeletedAt time.Time // when it was trashed
+	TrashDir  string    // the trash directory it is in
+}
+
+func (item trashedItem) filePath() string {
+	return filepath.Join(item.TrashDir, "files", item.Name)
+}
+
+func (item trashedItem) infoPath() string {
+	return filepath.Join(item.TrashDir, "info", item.Name+".trashinfo")
+}
+
+// The trash in the home directory: $XDG_DATA_HOME/Trash (~/.local/share/Trash by default)
+func homeTrashDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		dataHome = filepath.Join(home, ".local", "share")
+	}
+	return filepath.Join(dataHome, "Trash"), nil
+}
+
+// The directory a mount point keeps its trash in for the current user. The shared $topdir/.Trash
+// is used when an admin has set it up (a sticky directory, not a symlink), otherwise the file
+// goes to $topdir/.Trash-$uid.
+func topdirTrashDir(topdir string) string {
+	uid := strconv.Itoa(os.Getuid())
+
+	shared := filepath.Join(topdir, ".Trash")
+	if info, err := os.Lstat(shared); err == nil && info.IsDir() && info.Mode()&os.ModeSticky != 0 {
+		return filepath.Join(shared, uid)
+	}
+	return filepath.Join(topdir, ".Trash-"+uid)
+}
+
+// The directory a path is mounted on: the last parent that is still on the same device
+func mountPoint(path string) (string, error) {
+	dev, err := deviceID(path)
+	if err != nil {
+		return "", err
+	}
+	for {
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path, nil
+		}
+		parentDev, err := deviceID(parent)
+		if err != nil || parentDev != dev {
+			return path, nil
+		}
+		path = parent
+	}
+}
+
+// The closest existing directory, the home trash might not have been created yet
+func existingParent(path string) string {
+	for {
+		if _, err := os.Stat(path); err == nil {
+			return path
+		}
+		parent := filepath.Dir(path)
+		if parent == path {
+			return path
+		}
+		path = parent
+	}
+}
+
+// The trash directories a file could go to, best one first. Files on the same device as the home
+// trash go there, others to the trash of their own mount with the home trash as a last resort.
+func trashDirsFor(path string) ([]string, error) {
+	home, err := homeTrashDir()
+	if err != nil {
+		return nil, err
+	}
+
+	fileDev, err := deviceID(path)
+	if err != nil {
+		return []string{home}, nil
+	}
+	homeDev, err := deviceID(existingParent(home))
+	if err != nil || homeDev == fileDev {
+		return []string{home}, nil
+	}
+
+	topdir, err := mountPoint(path)
+	if err != nil {
+		return []string{home}, nil
+	}
+	return []string{topdirTrashDir(topdir), home}, nil
+}
+
+// Moves a file into the trash
+func moveToTrash(file string) error {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return err
+	}
+
+	dirs, err := trashDirsFor(abs)
+	if err != nil {
+		return err
+	}
+
+	var errs []error
+	for _, dir := range dirs {
+		err := trashInto(dir, abs)
+		if err == nil {
+			return nil
+		}
+		errs = append(errs, err)
+	}
+	return fmt.Errorf("could not move %s to the trash: %w", file, errors.Join(errs...))
+}
+
+func trashInto(dir, abs string) error {
+	if err := os.MkdirAll(filepath.Join(dir, "files"), 0700); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "info"), 0700); err != nil {
+		return err
+	}
+
+	// The info file is created first and exclusively, that is what reserves the name
+	base := filepath.Base(abs)
+	name := base
+	var info *os.File
+	for i := 2; ; i++ {
+		var err error
+		info, err = os.OpenFile(filepath.Join(dir, "info", name+".trashinfo"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, os.ErrExist) {
+			return err
+		}
+		name = base + "." + strconv.Itoa(i)
+	}
+	item := trashedItem{Name: name, Path: abs, DeletedAt: time.Now(), TrashDir: dir}
+
+	_, err := fmt.Fprintf(info, "[Trash Info]\nPath=%s\nDeletionDate=%s\n",
+		(&url.URL{Path: abs}).EscapedPath(), item.DeletedAt.Format(trashInfoDateFormat))
+	if closeErr := info.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = moveAcrossDevices(abs, item.filePath())
+	}
+	if err != nil {
+		os.Remove(item.infoPath())
+		return err
+	}
+	return nil
+}
+
+// Renames a file, copying it over when the rename is not possible across devices
+func moveAcrossDevices(from, to string) error {
+	err := os.Rename(from, to)
+	if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	info, err := os.Lstat(from)
+	if err != nil {
+		return err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		target, err := os.Readlink(from)
+		if err != nil {
+			return err
+		}
+		err = os.Symlink(target, to)
+	} else if info.Mode().IsRegular() {
+		if err := copyFileTo(from, to); err != nil {
+			return err
+		}
+		err = preserveAttributes(from, to, defaultPreserve)
+	} else {
+		return fmt.Errorf("%s: only files can be moved across devices", from)
+	}
+	if err != nil {
+		return err
+	}
+	return os.Remove(from)
+}
+
+// The mount point of a trash directory: the parent of $topdir/.Trash-$uid, or the parent of the
+// shared .Trash for $topdir/.Trash/$uid
+func trashTopdir(dir string) string {
+	parent := filepath.Dir(dir)
+	if filepath.Base(parent) == ".Trash" {
+		return filepath.Dir(parent)
+	}
+	return parent
+}
+
+// Whether a candidate is in the trash directory of a mount point (.Trash or .Trash-$uid)
+func inTrashDir(file string) bool {
+	first, _, _ := strings.Cut(file, "/")
+	return first == ".Trash" || strings.HasPrefix(first, ".Trash-")
+}
+
+// Reads a .trashinfo file
+func readTrashInfo(dir, infoFile string) (trashedItem, error) {
+	item := trashedItem{Name: strings.TrimSuffix(filepath.Base(infoFile), ".trashinfo"), TrashDir: dir}
+
+	f, err := os.Open(infoFile)
+	if err != nil {
+		return item, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if !ok {
+			continue
+		}
+		switch key {
+		case "Path":
+			if item.Path, err = url.PathUnescape(value); err != nil {
+				return item, err
+			}
+			// Paths in the trash of a mount point may be relative to it
+			if !filepath.IsAbs(item.Path) {
+				item.Path = filepath.Join(trashTopdir(dir), item.Path)
+			}
+		case "DeletionDate":
+			item.DeletedAt, _ = time.ParseInLocation(trashInfoDateFormat, value, time.Local)
+		}
+	}
+	if item.Path == "" {
+		return item, fmt.Errorf("%s: missing Path", infoFile)
+	}
+	return item, scanner.Err()
+}
+
+// Every trash directory we know of: the home trash and the ones of the mounted filesystems
+func trashDirs() []string {
+	dirs := []string{}
+	if home, err := homeTrashDir(); err == nil {
+		dirs = append(dirs, home)
+	}
+
+	uid := strconv.Itoa(os.Getuid())
+	for _, mount := range mountPoints() {
+		for _, dir := range []string{filepath.Join(mount, ".Trash", uid), filepath.Join(mount, ".Trash-"+uid)} {
+			if info, err := os.Stat(dir); err == nil && info.IsDir() && !slices.Contains(dirs, dir) {
+				dirs = append(dirs, dir)
+			}
+		}
+	}
+	return dirs
+}
+
+// Lists everything in the trash, most recently trashed first
+func listTrash() ([]trashedItem, error) {
+	items := []trashedItem{}
+	for _, dir := range trashDirs() {
+		infos, err := os.ReadDir(filepath.Join(dir, "info"))
+		if errors.Is(err, fs.ErrNotExist) {
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		for _, info := range infos {
+			if !strings.HasSuffix(info.Name(), ".trashinfo") {
+				continue
+			}
+			item, err := readTrashInfo(dir, filepath.Join(dir, "info", info.Name()))
+			if err != nil {
+				continue
+			}
+			items = append(items, item)
+		}
+	}
+
+	slices.SortStableFunc(items, func(a, b trashedItem) int {
+		return b.DeletedAt.Compare(a.DeletedAt)
+	})
+	return items, nil
+}
+
+// Puts a trashed file back where it was. Refuses to write over something that is there now.
+func restoreFromTrash(item trashedItem) error {
+	if _, err := os.Lstat(item.Path); err == nil {
+		return fmt.Errorf("%s already exists, move it away first", item.Path)
+	}
+	if err := os.MkdirAll(filepath.Dir(item.Path), os.ModePerm); err != nil {
+		return err
+	}
+	if err := moveAcrossDevices(item.filePath(), item.Path); err != nil {
+		return err
+	}
+	return os.Remove(item.infoPath())
+}
+
+// Finds the most recently trashed item for a path (or the name it has in the trash)
+func findInTrash(pathOrName string) (trashedItem, error) {
+	abs, err := filepath.Abs(pathOrName)
+	if err != nil {
+		return trashedItem{}, err
+	}
+
+	items, err := listTrash()
+	if err != nil {
+		return trashedItem{}, err
+	}
+	for _, item := range items {
+		if item.Path == abs || item.Name == pathOrName {
+			return item, nil
+		}
+	}
+	return trashedItem{}, fmt.Errorf("%s is not in the trash", pathOrName)
+}
@@ -0,0 +1,12 @@
+package main
+
+import "errors"
+
+// There are no mount point trashes on windows, everything goes to the home trash
+func deviceID(path string) (uint64, error) {
+	return 0, errors.ErrUnsupported
+}
+
+func mountPoints() []string {
+	return nil
+}
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
+	"io"
 	"io/fs"
 	"log"
 	"os"
@@ -10,12 +16,39 @@ import (
 	"path/filepath"
 	"regexp"
 	"slices"
+	"strings"
 
 	"gopkg.in/yaml.v3"
 )
 
 var sampleConfig string = `# This is a sample config file
 
+# Which attributes of the original files to keep on the copies (mode, times, owner, xattrs, all or none)
+preserve: [mode, times]
+
+# Which folder gets a file that matches several sibling folders: all of them (all), the first one (first) or
+# the one with the highest 'priority:' and then the deepest one (best). Folders can set their own policy too.
+policy: all
+
+# Uncomment to collect the files that do not match any folder below
+# unmatched: 'other'
+
+# Uncomment to avoid writing the same contents more than once (skip, hardlink, symlink or report)
+# dedupe: hardlink
+
+# Options for 'fileo sync', which keeps the folders below in sync with the files they match
+# sync:
+#   trash: 'fileo_trash'  # removed files are moved here instead of the system trash
+
+# Files that would be written over or removed are moved to the trash, set this to destroy them instead
+# delete_permanently: true
+
+# Every folder can also set how files are put into it with 'action' (copy, move, symlink, hardlink
+# or reflink). Sub-folders inherit it. For example:
+#   action: symlink
+#   symlink_target: absolute  # relative by default
+#   link_fallback: copy       # used when a hardlink/reflink is not possible (copy, symlink or fail)
+
 # Filters out all documents (txt, pdf and docx) which have dates in their names
 folders:
 - name: 'dated_documents'
@@ -47,11 +80,14 @@ folders:
       extensions: ["docx"]
   `
 
-func copyMatchedFiles(fileList []string, outputPath string) error {
+// Copies the matched files into outputPath. This goes through executePlan like a config does, so
+// the files already there are put in the trash before they are overwritten.
+func copyMatchedFiles(fileList []string, outputPath string, preserve PreserveOptions) error {
+	ops := []Operation{}
 	for _, file := range fileList {
-		copyFile(file, outputPath)
+		ops = append(ops, Operation{Src: file, Dst: filepath.Join(outputPath, filepath.Base(file))})
 	}
-	return nil
+	return executePlan(ops, applyOptions{preserve: preserve})
 }
 
 // This functin organizes file using the name pattern
@@ -91,12 +127,12 @@ func getRegexMatches(regexPattern string) []string {
 // First gets the matches, then copies them over
 func OrganizeFilesByRegex(regexPattern, outputPath string) {
 	matches := getRegexMatches(regexPattern)
-	copyMatchedFiles(matches, outputPath)
+	HandleError(copyMatchedFiles(matches, outputPath, defaultPreserve))
 }
 
 func OrganizeFilesByRegexRecursive(regexPattern, outputPath string) {
 	matches := getRegexMatchesRecursive(regexPattern)
-	copyMatchedFiles(matches, outputPath)
+	HandleError(copyMatchedFiles(matches, outputPath, defaultPreserve))
 }
 
 // TODO: this kind of feels repeated code as the non-recursive version so maybe put them together. But I kind of like that it is repeated since it is more clear for me to understand
@@ -128,50 +164,245 @@ func getRegexMatchesRecursive(regexPattern string) []string {
 	return matched
 }
 
+// Regex pattern matching the files with the given extension
+func extensionPattern(extension string) string {
+	return ".*\\." + extension + "$"
+}
+
 func getExtensionMatches(extension string) []string {
-	return getRegexMatches(".*\\." + extension + "$")
+	return getRegexMatches(extensionPattern(extension))
 }
 
 func getExtensionMatchesRecursive(extension string) []string {
-	return getRegexMatchesRecursive(".*\\." + extension + "$")
+	return getRegexMatchesRecursive(extensionPattern(extension))
+}
+
+// Lists every file within the current directory and its sub directories. These are the
+// candidates a config gets matched against, the paths are relative and use forward slashes.
+func listCandidates() ([]string, error) {
+	return listCandidatesContext(context.Background())
+}
+
+// Same as listCandidates, but stops walking once ctx is cancelled
+func listCandidatesContext(ctx context.Context) ([]string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	candidates := []string{}
+	err = fs.WalkDir(os.DirFS(dir), ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			candidates = append(candidates, path)
+		}
+		return nil
+	})
+	return candidates, err
+}
+
+// Reads the candidates from a list of files instead, one per line (or separated by NUL bytes,
+// as printed by find -print0). The paths are made relative to the current directory like the
+// ones of listCandidates, files outside of it and the ones that do not exist are left out.
+func readCandidates(r io.Reader, nulSeparated bool) ([]string, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+
+	separator := byte('\n')
+	if nulSeparated {
+		separator = 0
+	}
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	scanner.Split(func(data []byte, atEOF bool) (int, []byte, error) {
+		if i := bytes.IndexByte(data, separator); i >= 0 {
+			return i + 1, data[:i], nil
+		}
+		if atEOF && len(data) > 0 {
+			return len(data), data, nil
+		}
+		return 0, nil, nil
+	})
+
+	candidates := []string{}
+	seen := map[string]bool{}
+	for scanner.Scan() {
+		file := scanner.Text()
+		if !nulSeparated {
+			file = strings.TrimSuffix(file, "\r")
+		}
+		if file == "" {
+			continue
+		}
+
+		if !filepath.IsAbs(file) {
+			file = filepath.Join(dir, file)
+		}
+		rel, err := filepath.Rel(dir, file)
+		if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %s, it is not inside the current directory\n", file)
+			continue
+		}
+		info, err := os.Stat(rel)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: skipping %v\n", err)
+			continue
+		}
+		if info.IsDir() {
+			continue
+		}
+
+		rel = filepath.ToSlash(rel)
+		if !seen[rel] {
+			seen[rel] = true
+			candidates = append(candidates, rel)
+		}
+	}
+	return candidates, scanner.Err()
+}
+
+// Organizes the candidates (or every file when there are none) that match the regex pattern
+func OrganizeCandidates(candidates []string, regexPattern, outputPath string, recursive bool) error {
+	if candidates == nil {
+		var err error
+		if candidates, err = listCandidates(); err != nil {
+			return err
+		}
+	}
+	return copyMatchedFiles(matchCandidates(candidates, regexPattern, recursive), outputPath, defaultPreserve)
+}
+
+// Returns the candidates whose file name matches the regex pattern. Without recurse only the
+// files directly within the current directory are considered.
+func matchCandidates(candidates []string, regexPattern string, recurse bool) []string {
+	re := regexp.MustCompile(regexPattern)
+
+	matched := []string{}
+	for _, candidate := range candidates {
+		if !recurse && path.Dir(candidate) != "." {
+			continue
+		}
+		if re.MatchString(path.Base(candidate)) {
+			matched = append(matched, candidate)
+		}
+	}
+	return matched
 }
 
 // Organizes using file extension.
 func OrganizeFilesByExtension(outputPath, extension string) {
 	matches := getExtensionMatches(extension)
-	copyMatchedFiles(matches, outputPath)
+	HandleError(copyMatchedFiles(matches, outputPath, defaultPreserve))
 }
 
 // Organizes using file extension recursively.
 func OrganizeFilesByExtensionRecursive(outputPath, extension string) {
 	matches := getExtensionMatchesRecursive(extension)
-	copyMatchedFiles(matches, outputPath)
+	HandleError(copyMatchedFiles(matches, outputPath, defaultPreserve))
+}
+
+// Copies a source file to the destination folder. The contents are streamed into a temporary
+// file next to the destination which is synced and then renamed into place, so a crash can never
+// leave a half written file behind under the final name.
+func copyFile(src, dst string) error {
+	return copyFileWith(src, filepath.Join(dst, filepath.Base(src)), copyContents)
+}
+
+// Same as copyFile but dstPath is the full path of the copy, so it can have another name
+func copyFileTo(src, dstPath string) error {
+	return copyFileWith(src, dstPath, copyContents)
 }
 
-// Copies a source file to the destination folder
-func copyFile(src, dst string) {
+// Same as copyFile, but the contents are hashed on their way into the copy so they do not have to
+// be read a second time for the hash. The hash is empty when the copy could be made as a reflink,
+// which does not read them at all.
+func copyFileHashing(src, dst string) (string, error) {
+	h := sha256.New()
+	read := false
+	err := copyFileWith(src, filepath.Join(dst, filepath.Base(src)), func(out, in *os.File, size int64) error {
+		if err := cloneContents(out, in); err == nil {
+			return nil
+		}
+		read = true
+		_, err := io.Copy(out, io.TeeReader(in, h))
+		return err
+	})
+	if err != nil || !read {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Same as copyFile but the copy has to share its data blocks with the source (btrfs, xfs), it
+// fails on filesystems that can not do that instead of making a regular copy
+func reflinkFile(src, dst string) error {
+	return copyFileWith(src, filepath.Join(dst, filepath.Base(src)), func(out, in *os.File, size int64) error {
+		return cloneContents(out, in)
+	})
+}
+
+func copyFileWith(src, fullDstPath string, copyContents func(out, in *os.File, size int64) error) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	info, err := in.Stat()
+	if err != nil {
+		return err
+	}
 
 	// Create the destination folder if it does not exist already
-	if _, err := os.Stat(src); !os.IsNotExist(err) {
-		err := os.MkdirAll(dst, os.ModePerm)
-		HandleError(err)
+	dst, fileName := filepath.Split(fullDstPath)
+	if err := os.MkdirAll(filepath.Clean(dst), os.ModePerm); err != nil {
+		return err
 	}
 
-	data, err := os.ReadFile(src)
-	HandleError(err)
+	tmp, err := os.CreateTemp(filepath.Clean(dst), "."+fileName+".fileo-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := tmp.Name()
+
+	// Whatever goes wrong from here on, do not leave the temporary file around
+	renamed := false
+	defer func() {
+		if !renamed {
+			tmp.Close()
+			os.Remove(tmpPath)
+		}
+	}()
 
-	// Get the file name
-	_, fileName := filepath.Split(src)
+	if err := copyContents(tmp, in, info.Size()); err != nil {
+		return fmt.Errorf("copying %s: %w", src, err)
+	}
 
-	// Add the file name to the path
-	fullDstPath := filepath.Join(dst, fileName)
-	os.WriteFile(fullDstPath, data, 0644)
+	// CreateTemp makes the file private, use the usual permissions instead (preserve can override this)
+	if err := tmp.Chmod(0644); err != nil {
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
 
-	HandleError(err)
-	wd, _ := os.Getwd()
-	if false {
-		fmt.Println("the dir rn is", wd, src, dst)
+	if err := os.Rename(tmpPath, fullDstPath); err != nil {
+		return err
 	}
+	renamed = true
+
+	// The rename itself only lasts once the directory is synced
+	return syncDir(filepath.Clean(dst))
 }
 
 // Struct for how config should look
@@ -181,78 +412,482 @@ type Folder struct {
 	Patterns     []string `yaml:"patterns"`
 	Recurse      bool     `yaml:"recurse"`
 	ChildFolders []Folder `yaml:"folders"`
+
+	// How the matched files are put into the folder, these are inherited by the sub-folders
+	Action        string `yaml:"action"`         // copy (default), move, symlink, hardlink or reflink
+	SymlinkTarget string `yaml:"symlink_target"` // relative (default) or absolute
+	LinkFallback  string `yaml:"link_fallback"`  // copy (default), symlink or fail
+
+	// Which sub-folders get a file that several of them match: all (default), first or best.
+	// With best, the folder with the highest priority wins, then the deepest one.
+	// Priority is nil when it is not set, so an explicit 0 is not mistaken for "inherit".
+	Policy   string `yaml:"policy"`
+	Priority *int   `yaml:"priority"`
+
+	line      int              // where the folder starts in the config, for the error messages
+	keyLines  map[string]int   // line of each key of the folder
+	itemLines map[string][]int // line of each item of the lists (patterns, extensions)
+}
+
+// The line a setting of the folder is on, or where the folder starts when it does not set it
+func (f Folder) keyLine(key string) int {
+	if line, ok := f.keyLines[key]; ok {
+		return line
+	}
+	return f.line
+}
+
+// The line of one item of a list setting of the folder
+func (f Folder) itemLine(key string, i int) int {
+	if lines := f.itemLines[key]; i < len(lines) {
+		return lines[i]
+	}
+	return f.keyLine(key)
+}
+
+// The priority of the folder for the best policy, 0 when neither it nor a parent set one
+func (f Folder) priorityValue() int {
+	if f.Priority == nil {
+		return 0
+	}
+	return *f.Priority
+}
+
+func (f *Folder) UnmarshalYAML(value *yaml.Node) error {
+	type plain Folder
+	if err := value.Decode((*plain)(f)); err != nil {
+		return err
+	}
+	f.line = value.Line
+	f.keyLines = map[string]int{}
+	f.itemLines = map[string][]int{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		key, setting := value.Content[i].Value, value.Content[i+1]
+		f.keyLines[key] = value.Content[i].Line
+		if setting.Kind == yaml.SequenceNode {
+			for _, item := range setting.Content {
+				f.itemLines[key] = append(f.itemLines[key], item.Line)
+			}
+		}
+	}
+	return nil
 }
 
+// How a file matched by several sibling folders is handled
+const (
+	policyAll   = "all"
+	policyFirst = "first"
+	policyBest  = "best"
+)
+
 type ConfigData struct {
-	Folders []Folder `yaml:"folders"`
+	Folders  []Folder        `yaml:"folders"`
+	Preserve PreserveOptions `yaml:"preserve"`
+	Dedupe   string          `yaml:"dedupe"`
+	Sync     SyncOptions     `yaml:"sync"`
+
+	// Policy for the top level folders, and the default for all the others
+	Policy string `yaml:"policy"`
+
+	// Folder collecting the files that did not match any other folder
+	Unmatched string `yaml:"unmatched"`
+
+	// Files that get written over or removed go to the trash unless this is set
+	DeletePermanently bool `yaml:"delete_permanently"`
+
+	keyLines   map[string]int // line of each top level key, for the error messages
+	configFile string         // the file the config was read from, as a candidate path. It is never organized itself.
+}
+
+func (c *ConfigData) UnmarshalYAML(value *yaml.Node) error {
+	type plain ConfigData
+	if err := value.Decode((*plain)(c)); err != nil {
+		return err
+	}
+	c.keyLines = map[string]int{}
+	for i := 0; i+1 < len(value.Content); i += 2 {
+		c.keyLines[value.Content[i].Value] = value.Content[i].Line
+	}
+	return nil
 }
 
-// Takes in a config text input and outputs a list of strings that match the config file
-func ApplyConfig(yamlFile []byte) []string {
+// Reads, parses and validates a config file
+func loadConfigFile(fileName string) (ConfigData, error) {
+	yamlFile, err := os.ReadFile(fileName)
+	if err != nil {
+		return ConfigData{}, err
+	}
+	data, err := parseConfig(yamlFile)
+	if err != nil {
+		return data, err
+	}
+	data.configFile = candidatePath(fileName)
+	return data, nil
+}
 
-	var data ConfigData
-	err := yaml.Unmarshal(yamlFile, &data)
-	HandleError(err)
+// A path the way the candidates are written: relative to the current directory, with slashes.
+// Empty when it can not be made relative.
+func candidatePath(fileName string) string {
+	abs, err := filepath.Abs(fileName)
+	if err != nil {
+		return ""
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		return ""
+	}
+	rel, err := filepath.Rel(wd, abs)
+	if err != nil {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// Parses and validates a config
+func parseConfig(yamlFile []byte) (ConfigData, error) {
+	data := ConfigData{Preserve: defaultPreserve}
+	if err := yaml.Unmarshal(yamlFile, &data); err != nil {
+		return data, err
+	}
 
 	// ensuring the config is valid
 	if data.Folders == nil {
-		HandleError(errors.New("make sure your config has a folders directory"))
+		return data, errors.New("make sure your config has a folders directory")
+	}
+
+	switch data.Dedupe {
+	case "", dedupeSkip, dedupeHardlink, dedupeSymlink, dedupeReport:
+	default:
+		return data, fmt.Errorf("line %d: unknown dedupe option %q (expected skip, hardlink, symlink or report)", data.keyLines["dedupe"], data.Dedupe)
+	}
+
+	if data.Unmatched != "" && !insideName(data.Unmatched) {
+		return data, fmt.Errorf("line %d: the unmatched folder has to be a folder below the current directory (not ., .. or absolute)", data.keyLines["unmatched"])
+	}
+
+	if data.Policy == "" {
+		data.Policy = policyAll
+	}
+	if err := checkPolicy(data.Policy); err != nil {
+		return data, fmt.Errorf("line %d: %w", data.keyLines["policy"], err)
+	}
+
+	root := Folder{Action: actionCopy, SymlinkTarget: "relative", LinkFallback: fallbackCopy, Policy: data.Policy}
+	if err := prepareFolders(data.Folders, root); err != nil {
+		return data, err
+	}
+	return data, nil
+}
+
+func checkPolicy(policy string) error {
+	switch policy {
+	case policyAll, policyFirst, policyBest:
+		return nil
+	}
+	return fmt.Errorf("unknown policy %q (expected all, first or best)", policy)
+}
+
+// Whether a name is a folder below the one it is in. Anything else would have sync and the
+// unmatched folder treat the current directory (or something outside of it) as their own.
+func insideName(name string) bool {
+	clean := path.Clean(filepath.ToSlash(name))
+	return name != "" && clean != "." && clean != ".." && !strings.HasPrefix(clean, "../") && !path.IsAbs(clean) && !filepath.IsAbs(name)
+}
+
+// Checks the folder settings and fills in the ones that are inherited from the parent folder
+func prepareFolders(folders []Folder, parent Folder) error {
+	for i := range folders {
+		folder := &folders[i]
+
+		if !insideName(folder.Name) {
+			return fmt.Errorf("line %d: folder %q: the name has to be a folder inside the one it is in (not empty, ., .. or absolute)", folder.keyLine("name"), folder.Name)
+		}
+		if folder.Action == "" {
+			folder.Action = parent.Action
+		}
+		if folder.SymlinkTarget == "" {
+			folder.SymlinkTarget = parent.SymlinkTarget
+		}
+		if folder.LinkFallback == "" {
+			folder.LinkFallback = parent.LinkFallback
+		}
+		if folder.Policy == "" {
+			folder.Policy = parent.Policy
+		}
+		if folder.Priority == nil {
+			folder.Priority = parent.Priority
+		}
+		if err := checkPolicy(folder.Policy); err != nil {
+			return fmt.Errorf("line %d: folder %q: %w", folder.keyLine("policy"), folder.Name, err)
+		}
+
+		switch folder.Action {
+		case actionCopy, actionMove, actionSymlink, actionHardlink, actionReflink:
+		default:
+			return fmt.Errorf("line %d: folder %q: unknown action %q (expected copy, move, symlink, hardlink or reflink)", folder.keyLine("action"), folder.Name, folder.Action)
+		}
+		switch folder.SymlinkTarget {
+		case "relative", "absolute":
+		default:
+			return fmt.Errorf("line %d: folder %q: unknown symlink_target %q (expected relative or absolute)", folder.keyLine("symlink_target"), folder.Name, folder.SymlinkTarget)
+		}
+		switch folder.LinkFallback {
+		case fallbackCopy, fallbackSymlink, fallbackFail:
+		default:
+			return fmt.Errorf("line %d: folder %q: unknown link_fallback %q (expected copy, symlink or fail)", folder.keyLine("link_fallback"), folder.Name, folder.LinkFallback)
+		}
+
+		for i, pattern := range folder.Patterns {
+			if _, err := regexp.Compile(pattern); err != nil {
+				return fmt.Errorf("line %d: folder %q: invalid pattern: %w", folder.itemLine("patterns", i), folder.Name, err)
+			}
+		}
+		for i, extension := range folder.Extensions {
+			if _, err := regexp.Compile(extensionPattern(extension)); err != nil {
+				return fmt.Errorf("line %d: folder %q: invalid extension %q: %w", folder.itemLine("extensions", i), folder.Name, extension, err)
+			}
+		}
+
+		if err := prepareFolders(folder.ChildFolders, *folder); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Everything applying a config would do
+type Plan struct {
+	Operations []Operation `json:"operations"`
+	Unmatched  []string    `json:"unmatched"` // files no folder claimed, they go to the unmatched folder if there is one
+}
+
+// Works out all the operations applying the config would do. The config is matched against the
+// given candidate files, or against everything within the current directory when there are none.
+func planConfig(data ConfigData, candidates []string) (Plan, error) {
+	return planConfigTraced(data, candidates, nil)
+}
+
+// planConfig, also tracing how every folder handled the first candidate (see planConfigRecurse)
+func planConfigTraced(data ConfigData, candidates []string, traces *[]folderTrace) (Plan, error) {
+	if candidates == nil {
+		var err error
+		if candidates, err = listCandidates(); err != nil {
+			return Plan{}, err
+		}
 	}
 
 	// we enter here, there must always be a folders key in the yaml files
-	return applyConfigRecurse("", data.Folders, []string{}, true, false)
+	ops := resolveMoves(planConfigRecurse("", data.Folders, []string{}, true, candidates, data.Policy, traces))
+
+	unmatched := findUnmatched(data, candidates, ops)
+	if data.Unmatched != "" {
+		for _, file := range unmatched {
+			ops = append(ops, Operation{
+				Src:    file,
+				Dst:    path.Join(data.Unmatched, file),
+				Folder: data.Unmatched,
+				Action: actionCopy,
+			})
+		}
+	}
+	return Plan{Operations: ops, Unmatched: unmatched}, nil
+}
+
+// The candidates that no folder claimed. Only the files the config could have matched count, so
+// sub directories are left out when no folder recurses, and so are the config's own folders. The
+// config file itself and the trash directories are never unmatched either.
+func findUnmatched(data ConfigData, candidates []string, ops []Operation) []string {
+	recursive := slices.ContainsFunc(data.Folders, func(f Folder) bool { return f.Recurse })
+	managed := managedDirs(data)
+	if data.Sync.Trash != "" {
+		managed = append(managed, path.Clean(filepath.ToSlash(data.Sync.Trash)))
+	}
+
+	claimed := map[string]bool{}
+	for _, op := range ops {
+		claimed[op.Src] = true
+	}
+
+	unmatched := []string{}
+	for _, candidate := range candidates {
+		if !recursive && path.Dir(candidate) != "." {
+			continue
+		}
+		if claimed[candidate] || candidate == data.configFile || insideDirs(candidate, managed) || inTrashDir(candidate) {
+			continue
+		}
+		unmatched = append(unmatched, candidate)
+	}
+	return unmatched
+}
+
+// Lists the unmatched files at the end of a run, only the first few when there are many
+func printUnmatchedReport(w io.Writer, data ConfigData, unmatched []string) {
+	const maxListed = 10
+	if len(unmatched) == 0 {
+		return
+	}
+
+	if data.Unmatched != "" {
+		fmt.Fprintf(w, "%s did not match any folder and went into %s:\n", plural(len(unmatched), "file"), data.Unmatched)
+	} else {
+		fmt.Fprintf(w, "%s did not match any folder:\n", plural(len(unmatched), "file"))
+	}
+	for _, file := range unmatched[:min(len(unmatched), maxListed)] {
+		fmt.Fprintf(w, "  %s\n", file)
+	}
+	if len(unmatched) > maxListed {
+		fmt.Fprintf(w, "  ... and %d more\n", len(unmatched)-maxListed)
+	}
+}
+
+// Takes in a config text input, organizes the files and outputs a list of the source files that matched the config file
+func ApplyConfig(yamlFile []byte, opts applyOptions) []string {
+	data, err := parseConfig(yamlFile)
+	HandleError(err)
+	return applyConfigData(data, opts)
+}
+
+// Organizes the files with a parsed config, see ApplyConfig
+func applyConfigData(data ConfigData, opts applyOptions) []string {
+	plan, err := planConfig(data, opts.candidates)
+	HandleError(err)
+	HandleError(applyPlan(data, plan.Operations, opts))
+	printUnmatchedReport(os.Stdout, data, plan.Unmatched)
+
+	unmatched := map[string]bool{}
+	for _, file := range plan.Unmatched {
+		unmatched[file] = true
+	}
+	matched := []string{}
+	for _, op := range plan.Operations {
+		if !unmatched[op.Src] {
+			matched = append(matched, op.Src)
+		}
+	}
+	return matched
+}
+
+// Carries out the operations planned for a config, taking care of the config level options
+func applyPlan(data ConfigData, ops []Operation, opts applyOptions) error {
+	// Leave out what was already organized by an earlier run, and work out what to remember
+	// about the rest before anything gets moved away
+	ops, skipped := opts.pending(ops, data.Dedupe)
+	if skipped > 0 {
+		fmt.Fprintf(opts.output(os.Stdout), "Skipping %s already organized (use -full to redo them)\n", plural(skipped, "file"))
+	}
+	entries := map[string][]stateEntry{}
+	if opts.state != nil {
+		for _, op := range ops {
+			if entry, err := opts.state.entryFor(op); err == nil {
+				entries[op.Dst] = append(entries[op.Dst], entry)
+			}
+		}
+	}
+
+	deduped, duplicates, err := dedupeOperations(ops, data.Dedupe)
+	if err != nil {
+		return err
+	}
+	duplicatesBytes := duplicatesSize(duplicates)
+
+	opts.preserve = data.Preserve
+	opts.deletePermanently = data.DeletePermanently
+	if opts.state != nil {
+		// Hashing reads the contents through userspace, so only when the hash is not known yet
+		opts.hashContents = func(op Operation) bool {
+			return slices.ContainsFunc(entries[op.Dst], func(entry stateEntry) bool { return entry.Hash == "" })
+		}
+		opts.onDone = func(op Operation, hash string) {
+			for _, entry := range entries[op.Dst] {
+				if entry.Hash == "" {
+					entry.Hash = hash
+				}
+				opts.state.record(entry)
+			}
+		}
+	}
+
+	err = executePlan(deduped, opts)
+	printDedupeReport(opts.output(os.Stdout), duplicates, data.Dedupe, duplicatesBytes)
+	if opts.state != nil {
+		// Skipped duplicates are done too, or they would be hashed again by every run
+		if data.Dedupe == dedupeSkip {
+			for _, op := range duplicates {
+				for _, entry := range entries[op.Dst] {
+					entry.Skipped = true
+					opts.state.record(entry)
+				}
+			}
+		}
+		if saveErr := opts.state.save(); saveErr != nil {
+			err = errors.Join(err, fmt.Errorf("failed to save state: %w", saveErr))
+		}
+	}
+	return err
 }
 
 // ApplyConfigPreview returns destination paths for preview (where files will be organized to)
 func ApplyConfigPreview(yamlFile []byte) []string {
-	var data ConfigData
-	err := yaml.Unmarshal(yamlFile, &data)
-	if err != nil {
-		return []string{}
+	destinations := []string{}
+	for _, op := range PreviewConfigPlan(yamlFile).Operations {
+		destinations = append(destinations, op.Dst)
 	}
+	return destinations
+}
 
-	if data.Folders == nil {
-		return []string{}
+// PreviewConfigPlan returns what applying the config would do, without doing it
+func PreviewConfigPlan(yamlFile []byte) Plan {
+	data, err := parseConfig(yamlFile)
+	if err != nil {
+		return Plan{}
 	}
 
-	return applyConfigRecurse("", data.Folders, []string{}, true, true)
+	plan, err := planConfig(data, nil)
+	if err != nil {
+		return Plan{}
+	}
+	return plan
 }
 
 // A function to read the config file recursively and apply the desired structure
-func ApplyConfigFromFile(fileName string) error {
-	yamlFile, err := os.ReadFile(fileName)
+func ApplyConfigFromFile(fileName string, opts applyOptions) error {
+	data, err := loadConfigFile(fileName)
 	HandleError(err)
-	ApplyConfig(yamlFile)
+	applyConfigData(data, opts)
 	return nil
 }
 
 // NOTE: General behavior now: if the user specifies a folder within a folder in the config file,
 // then the inner folder will only match the files from the ones that matched with the parent file.
-// NOTE: also, if a file matches in multiple patterns, the default behavior will create a copy of a file for each match.
-// (both the above can be modified but thats the current implementation)
-func applyConfigRecurse(parentDir string, folders []Folder, parentMatches []string, firstRun bool, preview bool) []string {
-	currTotalMatches := []string{}
+// NOTE: also, if a file matches in multiple sibling folders, the default behavior (policy: all) will create a copy of
+// a file for each match. The policy decides which of the siblings get it otherwise, see resolvePolicy.
+// Nothing is copied here, this only works out the operations that applying the config would do.
+// When traces is not nil, a folderTrace for every folder is added to it, saying how the folder
+// handled the first candidate. That is what explain uses, with that one file as the candidate.
+func planConfigRecurse(parentDir string, folders []Folder, parentMatches []string, firstRun bool, candidates []string, policy string, traces *[]folderTrace) []Operation {
+	siblingOps := [][]Operation{}
+	tracing := traces != nil && len(candidates) > 0
 
 	for _, folder := range folders {
+		ops := []Operation{}
+		newPath := path.Join(parentDir, folder.Name)
+		trace := folderTrace{Folder: newPath, Priority: folder.priorityValue(), Matchers: []matcherTrace{}}
 
 		extensionMatches := []string{}
 		patternMatches := []string{}
 
 		// Handle the extensions
 		for _, extension := range folder.Extensions {
-			if folder.Recurse {
-				extensionMatches = append(extensionMatches, getExtensionMatchesRecursive(extension)...)
-			} else {
-				extensionMatches = append(extensionMatches, getExtensionMatches(extension)...)
-			}
+			found := matchCandidates(candidates, extensionPattern(extension), folder.Recurse)
+			extensionMatches = append(extensionMatches, found...)
+			trace.Matchers = append(trace.Matchers, matcherTrace{Kind: "extension", Value: extension, Matched: len(found) > 0})
 		}
 
 		for _, pattern := range folder.Patterns {
-			if folder.Recurse {
-				patternMatches = append(patternMatches, getRegexMatchesRecursive(pattern)...)
-			} else {
-				patternMatches = append(patternMatches, getRegexMatches(pattern)...)
-			}
+			found := matchCandidates(candidates, pattern, folder.Recurse)
+			patternMatches = append(patternMatches, found...)
+			trace.Matchers = append(trace.Matchers, matcherTrace{Kind: "pattern", Value: pattern, Matched: len(found) > 0})
 		}
 
 		// TODO: this part could use some work
@@ -283,37 +918,109 @@ func applyConfigRecurse(parentDir string, folders []Folder, parentMatches []stri
 			matchesParentCommon = currMatches
 		}
 
-		newPath := path.Join(parentDir, folder.Name)
-
 		// If a file has been covered by a subfolder, just skip it
 		matches := []string{}
 		if len(folder.ChildFolders) == 0 {
 			matches = matchesParentCommon
 		} else {
-			childrenMatches := applyConfigRecurse(newPath, folder.ChildFolders, matchesParentCommon, false, preview)
-			currTotalMatches = append(currTotalMatches, childrenMatches...)
+			var childTraces *[]folderTrace
+			if tracing {
+				childTraces = &trace.Children
+			}
+			childrenOps := planConfigRecurse(newPath, folder.ChildFolders, matchesParentCommon, false, candidates, folder.Policy, childTraces)
+			ops = append(ops, childrenOps...)
 
 			for _, match := range matchesParentCommon {
-				if !slices.Contains(childrenMatches, match) {
+				claimed := slices.ContainsFunc(childrenOps, func(op Operation) bool { return op.Src == match })
+				if !claimed {
 					matches = append(matches, match)
 				}
 			}
+			for _, op := range childrenOps {
+				if !slices.Contains(trace.ClaimedBy, op.Folder) {
+					trace.ClaimedBy = append(trace.ClaimedBy, op.Folder)
+				}
+			}
 		}
 
-		if preview {
-			// Convert source paths to destination paths
-			for _, match := range matches {
-				destPath := path.Join(newPath, filepath.Base(match))
-				currTotalMatches = append(currTotalMatches, destPath)
+		for _, match := range matches {
+			ops = append(ops, Operation{
+				Src:          match,
+				Dst:          path.Join(newPath, filepath.Base(match)),
+				Folder:       newPath,
+				Action:       folder.Action,
+				AbsoluteLink: folder.SymlinkTarget == "absolute",
+				Fallback:     folder.LinkFallback,
+				priority:     folder.priorityValue(),
+			})
+		}
+		siblingOps = append(siblingOps, ops)
+
+		if tracing {
+			file := candidates[0]
+			trace.InScope = folder.Recurse || path.Dir(file) == "."
+			trace.Matched = slices.Contains(currMatches, file)
+			trace.InParent = firstRun || slices.Contains(parentMatches, file)
+			if slices.Contains(matches, file) {
+				trace.Destination = path.Join(newPath, filepath.Base(file))
 			}
-		} else {
-			// Copy files and return source paths
-			copyMatchedFiles(matches, newPath)
-			currTotalMatches = append(currTotalMatches, matches...)
+			*traces = append(*traces, trace)
 		}
 	}
 
-	return currTotalMatches
+	resolved := resolvePolicy(siblingOps, policy)
+	if tracing {
+		// A sibling either keeps all its operations for the file or loses all of them to the policy
+		traced := (*traces)[len(*traces)-len(siblingOps):]
+		for i, ops := range siblingOps {
+			if len(ops) > 0 && !slices.Contains(resolved, ops[0]) {
+				traced[i].DroppedBy = policy
+			}
+		}
+	}
+	return resolved
+}
+
+// Decides which sibling folders get a file that several of them matched:
+//   - all: every one of them
+//   - first: only the first one (in the order of the config)
+//   - best: the one where it lands in the folder with the highest priority, and then the deepest one
+//
+// The operations of each sibling already include the ones of its sub-folders.
+func resolvePolicy(siblingOps [][]Operation, policy string) []Operation {
+	if policy != policyFirst && policy != policyBest {
+		return slices.Concat(siblingOps...)
+	}
+
+	// How good a place an operation puts its file in, higher is better
+	rank := func(op Operation) [2]int {
+		return [2]int{op.priority, strings.Count(op.Folder, "/")}
+	}
+	better := func(a, b [2]int) bool {
+		return a[0] > b[0] || a[0] == b[0] && a[1] > b[1]
+	}
+
+	winner := map[string]int{}  // file -> index of the sibling that gets it
+	best := map[string][2]int{} // file -> rank of the best place the winner has for it
+	for i, ops := range siblingOps {
+		for _, op := range ops {
+			// With best, a sibling with several places for the file is as good as its best one
+			if _, seen := winner[op.Src]; !seen || policy == policyBest && better(rank(op), best[op.Src]) {
+				winner[op.Src] = i
+				best[op.Src] = rank(op)
+			}
+		}
+	}
+
+	resolved := []Operation{}
+	for i, ops := range siblingOps {
+		for _, op := range ops {
+			if winner[op.Src] == i {
+				resolved = append(resolved, op)
+			}
+		}
+	}
+	return resolved
 }
 
 // General error handler function
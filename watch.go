@@ -0,0 +1,219 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const (
+	// A new file is only organized once its size has not changed for this long, so files that
+	// are still being written (downloads, copies) are left alone until they are done
+	watchSettleTime = 2 * time.Second
+	watchPollTime   = 500 * time.Millisecond
+)
+
+// Files with these extensions are still being written by some other program
+var partialFileSuffixes = []string{".part", ".partial", ".crdownload", ".download", ".tmp", ".swp"}
+
+// A file that was created or changed
+type fileEvent struct {
+	path    string // absolute
+	written bool   // closed after writing or moved in, so it is complete (and not truncated while being saved)
+}
+
+// Watches a directory for files being created or changed. Implemented per platform.
+type fileWatcher interface {
+	Events() <-chan fileEvent
+	Errors() <-chan error
+	Close() error
+}
+
+// A file that changed but has not settled yet
+type pendingFile struct {
+	size      int64
+	changedAt time.Time
+}
+
+// Keeps the config loaded by watch mode
+type watchState struct {
+	configPath string
+	root       string
+	data       ConfigData
+	recursive  bool
+}
+
+func (w *watchState) load() error {
+	data, err := loadConfigFile(w.configPath)
+	if err != nil {
+		return err
+	}
+	w.data = data
+
+	// Only the top level folders can match files in sub directories, the nested ones only ever
+	// see what their parent matched
+	w.recursive = slices.ContainsFunc(data.Folders, func(f Folder) bool { return f.Recurse })
+	return nil
+}
+
+// Whether a changed path should be organized. Leaves out our own output (the folders of the
+// config and the temporary files we write), the config itself and partially written files.
+func (w *watchState) wanted(relPath string) bool {
+	if relPath == "." || strings.HasPrefix(relPath, "../") {
+		return false
+	}
+	if !w.recursive && path.Dir(relPath) != "." {
+		return false
+	}
+
+	name := path.Base(relPath)
+	if strings.Contains(name, ".fileo-") {
+		return false
+	}
+	for _, suffix := range partialFileSuffixes {
+		if strings.HasSuffix(name, suffix) {
+			return false
+		}
+	}
+
+	if configRel, err := filepath.Rel(w.root, w.configPath); err == nil && filepath.ToSlash(configRel) == relPath {
+		return false
+	}
+
+	return !insideDirs(relPath, managedDirs(w.data))
+}
+
+// RunWatch keeps organizing the files arriving in the current directory using the config, until
+// it gets interrupted. The config is reloaded on SIGHUP or when the config file changes.
+func RunWatch(configPath string, opts applyOptions) error {
+	root, err := os.Getwd()
+	if err != nil {
+		return err
+	}
+	if configPath, err = filepath.Abs(configPath); err != nil {
+		return err
+	}
+
+	state := &watchState{configPath: configPath, root: root}
+	if err := state.load(); err != nil {
+		return fmt.Errorf("failed to load config: %w", err)
+	}
+
+	watcher, err := newFileWatcher(root, state.recursive, configPath)
+	if err != nil {
+		return err
+	}
+	defer func() { watcher.Close() }()
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP)
+	defer signal.Stop(signals)
+
+	ticker := time.NewTicker(watchPollTime)
+	defer ticker.Stop()
+
+	log.Printf("Watching %s (config: %s)\n", root, configPath)
+
+	pending := map[string]pendingFile{}
+	reload := false
+	for {
+		select {
+		case sig := <-signals:
+			if sig != syscall.SIGHUP {
+				log.Printf("Received %s, stopping\n", sig)
+				return nil
+			}
+			reload = true
+
+		case event := <-watcher.Events():
+			if event.path == configPath {
+				// Reading it while it is being saved could get half of it
+				reload = reload || event.written
+				continue
+			}
+			rel, err := filepath.Rel(root, event.path)
+			if err != nil {
+				continue
+			}
+			rel = filepath.ToSlash(rel)
+			if state.wanted(rel) {
+				pending[rel] = pendingFile{size: -1, changedAt: time.Now()}
+			}
+
+		case err := <-watcher.Errors():
+			log.Printf("Watch error: %v\n", err)
+
+		case <-ticker.C:
+			if reload {
+				reload = false
+				wasRecursive := state.recursive
+				if err := state.load(); err != nil {
+					log.Printf("Could not reload config, keeping the previous one: %v\n", err)
+					continue
+				}
+				log.Println("Reloaded config")
+
+				if state.recursive != wasRecursive {
+					watcher.Close()
+					if watcher, err = newFileWatcher(root, state.recursive, configPath); err != nil {
+						return err
+					}
+				}
+			}
+
+			ready := settledFiles(pending)
+			if len(ready) == 0 {
+				continue
+			}
+
+			plan, err := planConfig(state.data, ready)
+			if err != nil {
+				log.Printf("Failed to plan: %v\n", err)
+				continue
+			}
+			for _, op := range plan.Operations {
+				log.Printf("%s %s -> %s\n", op.Action, op.Src, op.Dst)
+			}
+			for _, file := range plan.Unmatched {
+				log.Printf("%s did not match any folder\n", file)
+			}
+			if err := applyPlan(state.data, plan.Operations, opts); err != nil {
+				log.Printf("Failed to organize some files: %v\n", err)
+			}
+		}
+	}
+}
+
+// Removes and returns the pending files whose size has stopped changing. Files that are gone
+// in the meantime are dropped.
+func settledFiles(pending map[string]pendingFile) []string {
+	ready := []string{}
+	now := time.Now()
+	for file, p := range pending {
+		info, err := os.Stat(file)
+		if err != nil || info.IsDir() {
+			delete(pending, file)
+			continue
+		}
+
+		if info.Size() != p.size {
+			pending[file] = pendingFile{size: info.Size(), changedAt: now}
+			continue
+		}
+		if now.Sub(p.changedAt) >= watchSettleTime {
+			ready = append(ready, file)
+			delete(pending, file)
+		}
+	}
+
+	// Keep the plan the same no matter how the map was iterated
+	slices.Sort(ready)
+	return ready
+}
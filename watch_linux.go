@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+const inotifyMask = unix.IN_CLOSE_WRITE | unix.IN_MOVED_TO | unix.IN_CREATE | unix.IN_MODIFY
+
+type inotifyWatcher struct {
+	fd        int
+	file      *os.File
+	recursive bool
+
+	mu      sync.Mutex
+	watches map[int]string // watch descriptor -> directory
+
+	events chan fileEvent
+	errors chan error
+	done   chan struct{}
+}
+
+// Watches root (and all its sub directories when recursive) with inotify. The directories of the
+// extra files are watched as well so changes to them are reported too.
+func newFileWatcher(root string, recursive bool, extra ...string) (fileWatcher, error) {
+	fd, err := unix.InotifyInit1(unix.IN_CLOEXEC | unix.IN_NONBLOCK)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &inotifyWatcher{
+		// Non blocking so reads go through the runtime poller and Close can interrupt them.
+		// The raw fd is kept around since calling Fd() would switch the file back to blocking.
+		fd:        fd,
+		file:      os.NewFile(uintptr(fd), "inotify"),
+		recursive: recursive,
+		watches:   map[int]string{},
+		events:    make(chan fileEvent),
+		errors:    make(chan error),
+		done:      make(chan struct{}),
+	}
+
+	if err := w.addDir(root, false); err != nil {
+		w.file.Close()
+		return nil, err
+	}
+	for _, file := range extra {
+		if dir := filepath.Dir(file); dir != root {
+			if err := w.watch(dir); err != nil {
+				w.file.Close()
+				return nil, err
+			}
+		}
+	}
+
+	go w.readEvents()
+	return w, nil
+}
+
+func (w *inotifyWatcher) Events() <-chan fileEvent { return w.events }
+func (w *inotifyWatcher) Errors() <-chan error     { return w.errors }
+
+func (w *inotifyWatcher) Close() error {
+	select {
+	case <-w.done:
+		return nil
+	default:
+		close(w.done)
+	}
+	return w.file.Close()
+}
+
+func (w *inotifyWatcher) watch(dir string) error {
+	wd, err := unix.InotifyAddWatch(w.fd, dir, inotifyMask)
+	if err != nil {
+		return err
+	}
+	w.mu.Lock()
+	w.watches[wd] = dir
+	w.mu.Unlock()
+	return nil
+}
+
+// Watches dir, and when recursive all the directories below it. With report the files already
+// in there are sent as events, for directories that were created (or moved in) after we started.
+func (w *inotifyWatcher) addDir(dir string, report bool) error {
+	if !w.recursive {
+		return w.watch(dir)
+	}
+
+	return filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// It might have been removed again in the meantime
+			if path != dir {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			return w.watch(path)
+		}
+		if report {
+			w.send(fileEvent{path: path})
+		}
+		return nil
+	})
+}
+
+func (w *inotifyWatcher) send(event fileEvent) {
+	select {
+	case w.events <- event:
+	case <-w.done:
+	}
+}
+
+func (w *inotifyWatcher) sendError(err error) {
+	select {
+	case w.errors <- err:
+	case <-w.done:
+	}
+}
+
+func (w *inotifyWatcher) readEvents() {
+	buf := make([]byte, 64*1024)
+	for {
+		n, err := w.file.Read(buf)
+		if err != nil {
+			if !errors.Is(err, os.ErrClosed) {
+				w.sendError(err)
+			}
+			return
+		}
+
+		for offset := 0; offset+unix.SizeofInotifyEvent <= n; {
+			event := (*unix.InotifyEvent)(unsafe.Pointer(&buf[offset]))
+			nameBytes := buf[offset+unix.SizeofInotifyEvent : offset+unix.SizeofInotifyEvent+int(event.Len)]
+			offset += unix.SizeofInotifyEvent + int(event.Len)
+
+			if event.Mask&unix.IN_Q_OVERFLOW != 0 {
+				w.sendError(errors.New("too many changes at once, some files were missed"))
+				continue
+			}
+			if event.Mask&unix.IN_IGNORED != 0 {
+				w.mu.Lock()
+				delete(w.watches, int(event.Wd))
+				w.mu.Unlock()
+				continue
+			}
+
+			w.mu.Lock()
+			dir, ok := w.watches[int(event.Wd)]
+			w.mu.Unlock()
+			if !ok {
+				continue
+			}
+			path := filepath.Join(dir, string(bytes.TrimRight(nameBytes, "\x00")))
+
+			if event.Mask&unix.IN_ISDIR != 0 {
+				if w.recursive && event.Mask&(unix.IN_CREATE|unix.IN_MOVED_TO) != 0 {
+					if err := w.addDir(path, true); err != nil {
+						w.sendError(err)
+					}
+				}
+				continue
+			}
+			w.send(fileEvent{path: path, written: event.Mask&(unix.IN_CLOSE_WRITE|unix.IN_MOVED_TO) != 0})
+		}
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !linux
+
+package main
+
+import "errors"
+
+// Watch mode relies on inotify which only exists on linux
+func newFileWatcher(root string, recursive bool, extra ...string) (fileWatcher, error) {
+	return nil, errors.New("watch mode is only supported on linux")
+}